@@ -2,68 +2,142 @@ package block
 
 import (
 	"bytes"
-	"encoding/gob"
+	"context"
+	"errors"
 	"log"
 	"time"
 
+	"github.com/pylrichard/building_block_chain_in_go/simple/codec"
 	"github.com/pylrichard/building_block_chain_in_go/simple/transaction"
 )
 
+//minTxLen 编码后交易的最小字节数
+const minTxLen = 6
+
 type Block struct {
 	Timestamp		int64
 	Transactions	[]*transaction.Transaction
 	PrevBlockHash	[]byte
+	//MerkleRoot 区块中所有交易的Merkle根，参与PoW计算
+	MerkleRoot		[]byte
 	Hash			[]byte
 	Nonce			int
 	Height			int
+	//Bits 区块哈希需要的前导0比特数，每retargetInterval个区块调整一次
+	Bits			int
+}
+
+func NewBlock(txs []*transaction.Transaction, prevBlockHash []byte, height, bits int) *Block {
+	b, err := NewBlockContext(context.Background(), txs, prevBlockHash, height, bits)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return b
 }
 
-func NewBlock(txs []*transaction.Transaction, prevBlockHash []byte, height int) *Block {
-	b := &Block{time.Now().Unix(), txs,
-		prevBlockHash, []byte{}, 0, height}
+//NewBlockContext 创建区块并挖矿，ctx取消时返回ctx.Err()
+func NewBlockContext(ctx context.Context, txs []*transaction.Transaction,
+					prevBlockHash []byte, height, bits int) (*Block, error) {
+	return newBlockContext(ctx, txs, prevBlockHash, height, bits, time.Now().Unix())
+}
+
+//newBlockContext 以timestamp为区块时间创建区块并挖矿
+func newBlockContext(ctx context.Context, txs []*transaction.Transaction,
+					prevBlockHash []byte, height, bits int, timestamp int64) (*Block, error) {
+	b := &Block{timestamp, txs,
+		prevBlockHash, nil, []byte{}, 0, height, bits}
+	b.MerkleRoot = b.HashTransaction()
 	pow := NewProofOfWork(b)
-	nonce, hash := pow.Run()
+	nonce, hash, err := pow.RunContext(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	b.Hash = hash[:]
 	b.Nonce = nonce
 
-	return b
+	return b, nil
 }
 
 func NewGenesisBlock(coinBase *transaction.Transaction) *Block {
-	return NewBlock([]*transaction.Transaction{coinBase}, []byte{}, 0)
+	return NewBlock([]*transaction.Transaction{coinBase}, []byte{}, 0, initialTargetBits)
 }
 
+//HashTransaction 返回区块中所有交易的Merkle根
 func (b *Block) HashTransaction() []byte {
+	return b.merkleTree().Root()
+}
+
+//GetMerkleProof 生成Id为txId的交易包含在区块中的证明，证明的Leaf为交易的MerkleLeaf
+func (b *Block) GetMerkleProof(txId []byte) (*MerkleProof, error) {
+	for i, tx := range b.Transactions {
+		if bytes.Equal(tx.Id, txId) {
+			return b.merkleTree().Proof(i)
+		}
+	}
+
+	return nil, errors.New("transaction is not found in block")
+}
+
+func (b *Block) merkleTree() *MerkleTree {
 	var txs [][]byte
 
+	//NewMerkleTree对每个交易的编码计算SHA-256，叶子与tx.MerkleLeaf()相同
 	for _, tx := range b.Transactions {
 		txs = append(txs, tx.Serialize())
 	}
 
-	return txs[1]
+	return NewMerkleTree(txs)
 }
 
+//Serialize 按以下格式编码区块：区块头 + 交易数量(varint) + 交易...
+//区块头的格式见BlockHeader.Serialize，交易的格式见transaction.Serialize
 func (b *Block) Serialize() []byte {
-	var result bytes.Buffer
-	encoder := gob.NewEncoder(&result)
+	w := codec.NewWriter()
+
+	b.Header().write(w)
+
+	w.WriteVarInt(uint64(len(b.Transactions)))
+	for _, tx := range b.Transactions {
+		w.WriteBytes(tx.Serialize())
+	}
+
+	return w.Bytes()
+}
 
-	err := encoder.Encode(b)
+func DeserializeBlock(data []byte) *Block {
+	block, err := DecodeBlock(data)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	return result.Bytes()
+	return block
 }
 
-func DeserializeBlock(data []byte) *Block {
-	var block Block
+//DecodeBlock 解析其他节点发送的区块，数据格式错误时返回error而不是panic
+func DecodeBlock(data []byte) (*Block, error) {
+	r := codec.NewReader(data)
 
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&block)
+	h, err := readHeader(r)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
+	}
+	block := h.toBlock()
+
+	txNum := r.ReadCount(minTxLen)
+	for i := 0; i < txNum; i++ {
+		tx, err := transaction.ReadTransaction(r)
+		if err != nil {
+			return nil, err
+		}
+		block.Transactions = append(block.Transactions, &tx)
+	}
+
+	err = r.Finish()
+	if err != nil {
+		return nil, err
 	}
 
-	return &block
+	return block, nil
 }
\ No newline at end of file
@@ -2,11 +2,16 @@ package block
 
 import (
 	"bytes"
+	"context"
+	"crypto/ecdsa"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
 	bolt "go.etcd.io/bbolt"
 
@@ -15,22 +20,64 @@ import (
 
 const dbFileNameTemplate = "block_chain_%s.db"
 const blocksBucket = "blocks"
+const chainWorkBucket = "chain_work"
+const maxOrphanBlocks = 500
 const genesisCoinBaseData = "The Times 03/Jan/2009 chancellor on brink of second bailout for banks"
 
+var (
+	ErrBlockExists			= errors.New("block already exists")
+	ErrInvalidPoW			= errors.New("block proof of work is invalid")
+	ErrInvalidMerkleRoot	= errors.New("block merkle root does not match its transactions")
+	ErrInvalidHeight		= errors.New("block height does not follow its parent")
+	ErrInvalidDifficulty	= errors.New("block difficulty does not match the retarget rule")
+	ErrInvalidTransaction	= errors.New("block contains invalid transaction")
+	ErrInvalidValue			= errors.New("transaction value is out of range")
+	ErrInsufficientInputs	= errors.New("transaction outputs exceed its inputs")
+	ErrInvalidCoinBase		= errors.New("coinbase pays more than subsidy plus fees")
+	ErrImmatureCoinBase		= errors.New("transaction spends immature coinbase output")
+	ErrStaleTip				= errors.New("chain tip changed while mining")
+	ErrMissingOutput		= errors.New("transaction spends missing or spent output")
+	ErrTxIdExists			= errors.New("transaction id already has unspent outputs")
+
+	errTxNotFound = errors.New("transaction is not found")
+)
+
 type Chain struct {
-	tip []byte
-	Db	*bolt.DB
+	tip			[]byte
+	Db			*bolt.DB
+	mu			sync.Mutex
+	//orphans 以PrevBlockHash为key保存父区块未知的区块
+	orphans		map[string][]*Block
+	orphanNum	int
+}
+
+//ChainUpdate 记录一次AddBlock引起的主链变化
+type ChainUpdate struct {
+	//Disconnected 从旧tip到分叉点被断开的区块，按高度从高到低排列
+	Disconnected	[]*Block
+	//Connected 从分叉点到新tip被连接的区块，按高度从低到高排列
+	Connected		[]*Block
+}
+
+func newChain(tip []byte, db *bolt.DB) *Chain {
+	return &Chain{tip: tip, Db: db, orphans: make(map[string][]*Block)}
 }
 
-func NewChainWithGenesis(addr, nodeId string) *Chain {
-	dbFileName := fmt.Sprintf(dbFileNameTemplate, nodeId)
+//NewChainWithGenesis 在dataDir中创建nodeId对应的数据库和创世块，dataDir不存在时创建
+func NewChainWithGenesis(addr, dataDir, nodeId string) *Chain {
+	dbFileName := dbFile(dataDir, nodeId)
 	if IsDbExists(dbFileName) {
 		fmt.Println("BlockChain already exists")
 		os.Exit(1)
 	}
 
+	err := os.MkdirAll(dataDir, 0700)
+	if err != nil {
+		log.Panic(err)
+	}
+
 	var tip []byte
-	cbTx := transaction.NewCoinBaseTx(addr, genesisCoinBaseData)
+	cbTx := transaction.NewCoinBaseTx(addr, genesisCoinBaseData, 0, 0)
 	genesis := NewGenesisBlock(cbTx)
 
 	db, err := bolt.Open(dbFileName, 0600, nil)
@@ -52,6 +99,29 @@ func NewChainWithGenesis(addr, nodeId string) *Chain {
 			log.Panic(err)
 		}
 
+		w, err := tx.CreateBucket([]byte(chainWorkBucket))
+		if err != nil {
+			log.Panic(err)
+		}
+		err = w.Put(genesis.Hash, NewProofOfWork(genesis).Work().Bytes())
+		if err != nil {
+			log.Panic(err)
+		}
+
+		h, err := tx.CreateBucket([]byte(heightIndexBucket))
+		if err != nil {
+			log.Panic(err)
+		}
+		err = h.Put(heightKey(genesis.Height), genesis.Hash)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		err = connectBlock(tx, genesis)
+		if err != nil {
+			log.Panic(err)
+		}
+
 		tip = genesis.Hash
 
 		return nil
@@ -60,13 +130,12 @@ func NewChainWithGenesis(addr, nodeId string) *Chain {
 		log.Panic(err)
 	}
 
-	bc := Chain{tip, db}
-
-	return &bc
+	return newChain(tip, db)
 }
 
-func NewChain(nodeId string) *Chain {
-	dbFileName := fmt.Sprintf(dbFileNameTemplate, nodeId)
+//NewChain 打开dataDir中nodeId对应的数据库
+func NewChain(dataDir, nodeId string) *Chain {
+	dbFileName := dbFile(dataDir, nodeId)
 	if IsDbExists(dbFileName) == false {
 		fmt.Println("No existing block chain found, Create new one first.")
 		os.Exit(1)
@@ -82,29 +151,87 @@ func NewChain(nodeId string) *Chain {
 		b := tx.Bucket([]byte(blocksBucket))
 		tip = b.Get([]byte("l"))
 
-		return nil
+		_, err := tx.CreateBucketIfNotExists([]byte(chainWorkBucket))
+		if err != nil {
+			return err
+		}
+
+		return buildHeightIndex(tx)
 	})
 	if err != nil {
 		log.Panic(err)
 	}
 
-	bc := Chain{tip, db}
-
-	return &bc
+	return newChain(tip, db)
 }
 
-func (bc *Chain) AddBlock(b *Block) {
+//AddBlock 保存从其他节点收到的区块，区块可能延伸主链、形成分支或在父区块到达前成为孤块
+//当某个分支的累计工作量超过主链时切换"l"并更新chainstate，返回被断开和被连接的区块以便更新mempool
+func (bc *Chain) AddBlock(b *Block) (*ChainUpdate, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	oldTip := bc.tip
+
+	connected, err := bc.storeBlock(b)
+	if err != nil {
+		return &ChainUpdate{}, err
+	}
+
+	//父区块到达后，依次连接等待它的孤块
+	for len(connected) > 0 {
+		parent := hex.EncodeToString(connected[0])
+		connected = connected[1:]
+
+		children := bc.orphans[parent]
+		delete(bc.orphans, parent)
+		bc.orphanNum -= len(children)
+
+		for _, child := range children {
+			ok, err := bc.storeBlock(child)
+			if err != nil {
+				fmt.Printf("Dropped orphan block %x: %s\n", child.Hash, err)
+				continue
+			}
+			connected = append(connected, ok...)
+		}
+	}
+
+	return bc.getChainUpdate(oldTip, bc.tip)
 }
 
 func (bc *Chain) FindTransaction(Id []byte) (transaction.Transaction, error) {
-	bci := bc.Iterator()
+	return bc.FindTransactionFrom(bc.tip, Id)
+}
+
+//FindMainChainTransaction 在主链上查找交易，同时返回交易所在区块的高度
+func (bc *Chain) FindMainChainTransaction(Id []byte) (transaction.Transaction, int, error) {
+	return bc.FindTransactionWithHeight(bc.tip, Id)
+}
+
+//FindTransactionFrom 从指定区块开始往回查找交易，用于处理不在主链上的分支
+func (bc *Chain) FindTransactionFrom(blockHash, Id []byte) (transaction.Transaction, error) {
+	tx, _, err := bc.FindTransactionWithHeight(blockHash, Id)
+
+	return tx, err
+}
+
+//FindTransactionWithHeight 和FindTransactionFrom相同，同时返回交易所在区块的高度
+//启用交易索引且blockHash为当前tip时通过索引查找
+func (bc *Chain) FindTransactionWithHeight(blockHash, Id []byte) (transaction.Transaction, int, error) {
+	tx, height, err := bc.findIndexedTransaction(blockHash, Id)
+	if err != errNoIndex {
+		return tx, height, err
+	}
+
+	bci := &ChainIterator{blockHash, bc.Db}
 
 	for {
 		block := bci.Next()
 
 		for _, tx := range block.Transactions {
 			if bytes.Compare(tx.Id, Id) == 0 {
-				return *tx, nil
+				return *tx, block.Height, nil
 			}
 		}
 
@@ -113,7 +240,7 @@ func (bc *Chain) FindTransaction(Id []byte) (transaction.Transaction, error) {
 		}
 	}
 
-	return transaction.Transaction{}, errors.New("transaction is not found")
+	return transaction.Transaction{}, 0, errTxNotFound
 }
 
 func (bc *Chain) Iterator() *ChainIterator {
@@ -181,72 +308,242 @@ func (bc *Chain) GetBlockHashes() [][]byte {
 	return blocks
 }
 
-func (bc *Chain) MineBlock(transactions []*transaction.Transaction) *Block {
-	var lastHash []byte
-	var lastHeight int
+//MineBlock 在当前tip之后挖出包含transactions的区块，transactions不满足区块规则时返回error
+func (bc *Chain) MineBlock(transactions []*transaction.Transaction) (*Block, error) {
+	return bc.MineBlockContext(context.Background(), transactions)
+}
 
-	for _, tx := range transactions {
-		if bc.VerifyTransaction(tx) != true {
-			log.Panic("Error: Invalid transaction")
-		}
-	}
+//MineBlockContext 在当前tip之后挖出包含transactions的区块，ctx取消时放弃挖矿
+//transactions的第一笔必须是Coinbase交易，挖矿期间tip被其他区块改变时返回ErrStaleTip
+func (bc *Chain) MineBlockContext(ctx context.Context, transactions []*transaction.Transaction) (*Block, error) {
+	var lastHash []byte
+	var lastBlock *Block
 
 	err := bc.Db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 		lastHash = b.Get([]byte("l"))
 
 		blockData := b.Get(lastHash)
-		block := DeserializeBlock(blockData)
-
-		lastHeight = block.Height
+		lastBlock = DeserializeBlock(blockData)
 
 		return nil
 	})
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
-	newBlock := NewBlock(transactions, lastHash, lastHeight + 1)
+	err = checkTransactionList(transactions)
+	if err != nil {
+		return nil, err
+	}
+
+	err = bc.checkTransactions(transactions, lastHash)
+	if err != nil {
+		return nil, err
+	}
+
+	bits, err := bc.GetNextBits(lastBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	//区块时间必须晚于median-time-past，连续快速挖矿时本地时间可能不满足
+	timestamp := time.Now().Unix()
+	mtp, err := medianTimePast(lastBlock.Header(), bc.getHeader)
+	if err != nil {
+		return nil, err
+	}
+	if timestamp <= mtp {
+		timestamp = mtp + 1
+	}
+
+	newBlock, err := newBlockContext(ctx, transactions, lastHash, lastBlock.Height + 1, bits, timestamp)
+	if err != nil {
+		return nil, err
+	}
+
+	err = bc.ValidateBlock(newBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
 
 	err = bc.Db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
+		if !bytes.Equal(b.Get([]byte("l")), lastHash) {
+			return ErrStaleTip
+		}
+
 		err := b.Put(newBlock.Hash, newBlock.Serialize())
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
 
 		err = b.Put([]byte("l"), newBlock.Hash)
 		if err != nil {
-			log.Panic(err)
+			return err
+		}
+
+		err = switchTip(tx, lastHash, newBlock.Hash)
+		if err != nil {
+			return err
+		}
+
+		err = putChainWork(tx, newBlock)
+		if err != nil {
+			return err
 		}
 
 		bc.tip = newBlock.Hash
 
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newBlock, nil
+}
+
+//SignTransaction 找到Transaction的Input引用的交易，对Input签名
+func (bc *Chain) SignTransaction(tx *transaction.Transaction, privKey ecdsa.PrivateKey) {
+	prevTxs, err := bc.findPrevTransactions(tx, bc.tip)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	return newBlock
+	tx.Sign(privKey, prevTxs)
+}
+
+//ValidateTransaction 验证签名和输入输出金额，引用的交易不存在时返回error而不是panic
+//用于验证其他节点发送的交易
+func (bc *Chain) ValidateTransaction(tx *transaction.Transaction) error {
+	if tx.IsCoinBase() {
+		return nil
+	}
+
+	_, err := bc.checkTransaction(tx, bc.tip)
+
+	return err
 }
 
-//VerifyTransaction 验证Transaction的Input Signatures
-func (bc *Chain) VerifyTransaction(tx *transaction.Transaction) bool {
+//GetTransactionFee 返回tx的手续费，即引用的输出总额减去tx的输出总额
+func (bc *Chain) GetTransactionFee(tx *transaction.Transaction) (int, error) {
 	if tx.IsCoinBase() {
-		return true
+		return 0, nil
+	}
+
+	prevTxs, err := bc.findPrevTransactions(tx, bc.tip)
+	if err != nil {
+		return 0, err
+	}
+
+	return tx.Fee(prevTxs)
+}
+
+//checkTransactions 验证区块中的交易，blockHash为区块的父区块，引用的输出必须位于它所在的分支上
+//Coinbase交易的输出总额不能超过区块奖励加上其他交易的手续费
+func (bc *Chain) checkTransactions(txs []*transaction.Transaction, blockHash []byte) error {
+	parent, err := bc.GetBlock(blockHash)
+	if err != nil {
+		return err
+	}
+
+	err = bc.checkUnspent(txs, blockHash)
+	if err != nil {
+		return err
 	}
 
+	fees := 0
+	coinBaseValue := 0
+
+	for _, tx := range txs {
+		if tx.IsCoinBase() {
+			value, err := tx.OutputValue()
+			if err != nil {
+				return ErrInvalidValue
+			}
+			coinBaseValue += value
+
+			continue
+		}
+
+		fee, err := bc.checkTransaction(tx, blockHash)
+		if err != nil {
+			return err
+		}
+		fees += fee
+		if !transaction.MoneyRange(fees) {
+			return ErrInvalidValue
+		}
+	}
+
+	if coinBaseValue > transaction.GetSubsidy(parent.Height + 1) + fees {
+		return ErrInvalidCoinBase
+	}
+
+	return nil
+}
+
+//checkTransaction 验证普通交易的签名和金额，返回手续费
+func (bc *Chain) checkTransaction(tx *transaction.Transaction, blockHash []byte) (int, error) {
+	_, err := tx.OutputValue()
+	if err != nil {
+		return 0, ErrInvalidValue
+	}
+
+	prevTxs, err := bc.findPrevTransactions(tx, blockHash)
+	if err != nil {
+		return 0, err
+	}
+
+	if !tx.Verify(prevTxs) {
+		return 0, ErrInvalidTransaction
+	}
+
+	fee, err := tx.Fee(prevTxs)
+	if err != nil {
+		return 0, ErrInvalidValue
+	}
+	if fee < 0 {
+		return 0, ErrInsufficientInputs
+	}
+
+	return fee, nil
+}
+
+//findPrevTransactions 从指定区块往回查找tx的Input引用的交易，并检查引用的Output存在
+//tx被打包在blockHash之后的区块中，引用的Coinbase交易必须已经成熟
+func (bc *Chain) findPrevTransactions(tx *transaction.Transaction, blockHash []byte) (map[string]transaction.Transaction, error) {
 	prevTxs := make(map[string]transaction.Transaction)
+
+	b, err := bc.GetBlock(blockHash)
+	if err != nil {
+		return nil, err
+	}
+	spendHeight := b.Height + 1
+
 	for _, input := range tx.In {
-		prevTx, err := bc.FindTransaction(input.TxId)
+		prevTx, height, err := bc.FindTransactionWithHeight(blockHash, input.TxId)
 		if err != nil {
-			log.Panic(err)
+			return nil, err
+		}
+		if input.Out < 0 || input.Out >= len(prevTx.Out) {
+			return nil, fmt.Errorf("transaction %x refers to missing output %d", prevTx.Id, input.Out)
+		}
+		if prevTx.IsCoinBase() && !transaction.IsMatureCoinBase(height, spendHeight) {
+			return nil, ErrImmatureCoinBase
 		}
 		prevTxs[hex.EncodeToString(prevTx.Id)] = prevTx
 	}
 
-	return tx.Verify(prevTxs)
+	return prevTxs, nil
+}
+
+func dbFile(dataDir, nodeId string) string {
+	return filepath.Join(dataDir, fmt.Sprintf(dbFileNameTemplate, nodeId))
 }
 
 func IsDbExists(dbFileName string) bool {
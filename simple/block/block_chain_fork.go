@@ -0,0 +1,261 @@
+package block
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"math/big"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+//storeBlock 验证并保存区块，父区块未知时放入孤块池
+//区块被保存时返回它的哈希，以便继续连接等待它的孤块
+func (bc *Chain) storeBlock(b *Block) ([][]byte, error) {
+	var parent *Block
+	var exists bool
+
+	err := checkBlockSanity(b)
+	if err != nil {
+		return nil, err
+	}
+
+	err = bc.Db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(blocksBucket))
+		exists = bucket.Get(b.Hash) != nil
+
+		parentData := bucket.Get(b.PrevBlockHash)
+		if parentData != nil {
+			parent = DeserializeBlock(parentData)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrBlockExists
+	}
+
+	if parent == nil {
+		bc.addOrphan(b)
+
+		return nil, nil
+	}
+
+	err = bc.checkBlockContext(b, parent)
+	if err != nil {
+		return nil, err
+	}
+
+	err = bc.Db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(blocksBucket))
+		if bucket.Get(b.Hash) != nil {
+			return ErrBlockExists
+		}
+
+		err := bucket.Put(b.Hash, b.Serialize())
+		if err != nil {
+			return err
+		}
+
+		err = putChainWork(tx, b)
+		if err != nil {
+			return err
+		}
+
+		tipHash := bucket.Get([]byte("l"))
+		if getChainWork(tx, b.Hash).Cmp(getChainWork(tx, tipHash)) > 0 {
+			err = bucket.Put([]byte("l"), b.Hash)
+			if err != nil {
+				return err
+			}
+
+			err = switchTip(tx, tipHash, b.Hash)
+			if err != nil {
+				return err
+			}
+
+			bc.tip = b.Hash
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return [][]byte{b.Hash}, nil
+}
+
+//addOrphan 把区块放入孤块池，池满时丢弃任意一个孤块
+func (bc *Chain) addOrphan(b *Block) {
+	if bc.orphanNum >= maxOrphanBlocks {
+		for prevHash, blocks := range bc.orphans {
+			bc.orphans[prevHash] = blocks[1:]
+			if len(bc.orphans[prevHash]) == 0 {
+				delete(bc.orphans, prevHash)
+			}
+			bc.orphanNum--
+
+			break
+		}
+	}
+
+	prevHash := hex.EncodeToString(b.PrevBlockHash)
+	for _, orphan := range bc.orphans[prevHash] {
+		if bytes.Equal(orphan.Hash, b.Hash) {
+			return
+		}
+	}
+
+	bc.orphans[prevHash] = append(bc.orphans[prevHash], b)
+	bc.orphanNum++
+}
+
+//hasOrphan 返回区块是否在孤块池中
+func (bc *Chain) hasOrphan(h BlockHeader) bool {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	for _, orphan := range bc.orphans[hex.EncodeToString(h.PrevBlockHash)] {
+		if bytes.Equal(orphan.Hash, h.Hash) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//getChainUpdate 找到新旧tip的分叉点，返回主链切换时需要断开和连接的区块
+func (bc *Chain) getChainUpdate(oldTip, newTip []byte) (*ChainUpdate, error) {
+	update := &ChainUpdate{}
+	if bytes.Equal(oldTip, newTip) {
+		return update, nil
+	}
+
+	oldBlock, err := bc.GetBlock(oldTip)
+	if err != nil {
+		return update, err
+	}
+	newBlock, err := bc.GetBlock(newTip)
+	if err != nil {
+		return update, err
+	}
+
+	var connected []*Block
+	for !bytes.Equal(oldBlock.Hash, newBlock.Hash) {
+		if oldBlock.Height >= newBlock.Height {
+			block := oldBlock
+			update.Disconnected = append(update.Disconnected, &block)
+
+			oldBlock, err = bc.GetBlock(oldBlock.PrevBlockHash)
+		} else {
+			block := newBlock
+			connected = append(connected, &block)
+
+			newBlock, err = bc.GetBlock(newBlock.PrevBlockHash)
+		}
+		if err != nil {
+			return update, errors.New("fork point is not found")
+		}
+	}
+
+	for i := len(connected) - 1; i >= 0; i-- {
+		update.Connected = append(update.Connected, connected[i])
+	}
+
+	return update, nil
+}
+
+//switchTip 主链从oldTip切换到newTip时更新chainstate和索引，调用者需要在同一个事务中修改"l"
+//新连接的区块花费的输出不在chainstate中时返回error，整个事务被回滚
+func switchTip(tx *bolt.Tx, oldTip, newTip []byte) error {
+	blocks := tx.Bucket([]byte(blocksBucket))
+	var connected []*Block
+
+	oldBlock, err := DecodeBlock(blocks.Get(oldTip))
+	if err != nil {
+		return err
+	}
+	newBlock, err := DecodeBlock(blocks.Get(newTip))
+	if err != nil {
+		return err
+	}
+
+	for !bytes.Equal(oldBlock.Hash, newBlock.Hash) {
+		if oldBlock.Height >= newBlock.Height {
+			err = disconnectBlock(tx, oldBlock)
+			if err != nil {
+				return err
+			}
+			err = unindexBlock(tx, oldBlock)
+			if err != nil {
+				return err
+			}
+
+			oldBlock, err = DecodeBlock(blocks.Get(oldBlock.PrevBlockHash))
+		} else {
+			connected = append(connected, newBlock)
+
+			newBlock, err = DecodeBlock(blocks.Get(newBlock.PrevBlockHash))
+		}
+		if err != nil {
+			return errors.New("fork point is not found")
+		}
+	}
+
+	for i := len(connected) - 1; i >= 0; i-- {
+		err = connectBlock(tx, connected[i])
+		if err != nil {
+			return err
+		}
+		err = indexBlock(tx, connected[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//putChainWork 保存从创世块到该区块的累计工作量
+func putChainWork(tx *bolt.Tx, b *Block) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(chainWorkBucket))
+	if err != nil {
+		return err
+	}
+
+	work := getChainWork(tx, b.PrevBlockHash)
+	work.Add(work, NewProofOfWork(b).Work())
+
+	return bucket.Put(b.Hash, work.Bytes())
+}
+
+//getChainWork 返回从创世块到该区块的累计工作量
+//旧数据库中没有记录的区块沿PrevBlockHash往回累加
+func getChainWork(tx *bolt.Tx, blockHash []byte) *big.Int {
+	works := tx.Bucket([]byte(chainWorkBucket))
+	blocks := tx.Bucket([]byte(blocksBucket))
+	total := big.NewInt(0)
+
+	for len(blockHash) > 0 {
+		if works != nil {
+			if data := works.Get(blockHash); data != nil {
+				return total.Add(total, new(big.Int).SetBytes(data))
+			}
+		}
+
+		data := blocks.Get(blockHash)
+		if data == nil {
+			break
+		}
+
+		b := DeserializeBlock(data)
+		total.Add(total, NewProofOfWork(b).Work())
+		blockHash = b.PrevBlockHash
+	}
+
+	return total
+}
@@ -0,0 +1,91 @@
+package block
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/transaction"
+	"github.com/pylrichard/building_block_chain_in_go/simple/wallet"
+)
+
+//hasUnspent 返回chainstate中是否有交易txId的未花费输出
+func hasUnspent(t *testing.T, bc *Chain, txId []byte) bool {
+	var ok bool
+
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		ok = tx.Bucket([]byte(ChainStateBucket)).Get(txId) != nil
+
+		return nil
+	})
+	assert.Nil(t, err)
+
+	return ok
+}
+
+func blockHashes(blocks []*Block) [][]byte {
+	var hashes [][]byte
+	for _, b := range blocks {
+		hashes = append(hashes, b.Hash)
+	}
+
+	return hashes
+}
+
+func TestAddBlockReorg(t *testing.T) {
+	w := wallet.NewWallet()
+	addr := string(w.GetAddr())
+	other := string(wallet.NewWallet().GetAddr())
+	bc := NewChainWithGenesis(addr, t.TempDir(), "fork_test")
+	defer bc.Db.Close()
+
+	genesis, err := bc.GetBlockByHeight(0)
+	assert.Nil(t, err)
+	genesisCb := genesis.Transactions[0]
+
+	spend := newSpendTx(bc, w, genesisCb, genesisCb.Out[0].Value, other)
+	main, err := bc.MineBlock([]*transaction.Transaction{transaction.NewCoinBaseTx(addr, "", 1, 0), spend})
+	assert.Nil(t, err)
+	assert.False(t, hasUnspent(t, bc, genesisCb.Id))
+	assert.True(t, hasUnspent(t, bc, spend.Id))
+
+	fork := mineBranch(t, other, &genesis, 2)
+
+	//父区块未知的区块进入孤块池，主链不变
+	update, err := bc.AddBlock(fork[1])
+	assert.Nil(t, err)
+	assert.Empty(t, update.Connected)
+	assert.True(t, bc.hasOrphan(fork[1].Header()))
+	assert.Equal(t, 1, bc.GetBestHeight())
+
+	//父区块到达后孤块被连接，分支的累计工作量超过主链，断开main并回滚chainstate
+	update, err = bc.AddBlock(fork[0])
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{main.Hash}, blockHashes(update.Disconnected))
+	assert.Equal(t, [][]byte{fork[0].Hash, fork[1].Hash}, blockHashes(update.Connected))
+	assert.False(t, bc.hasOrphan(fork[1].Header()))
+	assert.Equal(t, 2, bc.GetBestHeight())
+	assert.True(t, hasUnspent(t, bc, genesisCb.Id))
+	assert.False(t, hasUnspent(t, bc, spend.Id))
+	assert.False(t, hasUnspent(t, bc, main.Transactions[0].Id))
+	assert.True(t, hasUnspent(t, bc, fork[1].Transactions[0].Id))
+
+	//工作量相同的分支不会替换主链
+	extend := mineBranch(t, addr, main, 2)
+	update, err = bc.AddBlock(extend[0])
+	assert.Nil(t, err)
+	assert.Empty(t, update.Connected)
+	assert.Equal(t, fork[1].Hash, bc.tip)
+
+	//原来的分支重新超过后，重放main花费genesis输出的交易
+	update, err = bc.AddBlock(extend[1])
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{fork[1].Hash, fork[0].Hash}, blockHashes(update.Disconnected))
+	assert.Equal(t, [][]byte{main.Hash, extend[0].Hash, extend[1].Hash}, blockHashes(update.Connected))
+	assert.Equal(t, 3, bc.GetBestHeight())
+	assert.False(t, hasUnspent(t, bc, genesisCb.Id))
+	assert.True(t, hasUnspent(t, bc, spend.Id))
+	assert.False(t, hasUnspent(t, bc, fork[0].Transactions[0].Id))
+}
\ No newline at end of file
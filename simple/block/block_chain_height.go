@@ -0,0 +1,169 @@
+package block
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+//heightIndexBucket 保存主链上高度到区块哈希的映射，和"l"在同一个bolt事务中更新
+const heightIndexBucket = "height_index"
+
+//ChainForwardIterator 沿主链从低到高遍历区块，遍历期间主链切换时继续遍历新的主链
+type ChainForwardIterator struct {
+	db		*bolt.DB
+	height	int
+	//end 最后一个区块的高度，小于0时遍历到tip
+	end		int
+}
+
+//ForwardIterator 返回从高度start到end的迭代器，end小于0时遍历到tip
+func (bc *Chain) ForwardIterator(start, end int) *ChainForwardIterator {
+	return &ChainForwardIterator{bc.Db, start, end}
+}
+
+//ForwardIteratorFromHash 返回从主链上的区块hash开始的迭代器，最多返回count个区块，count小于0时遍历到tip
+func (bc *Chain) ForwardIteratorFromHash(hash []byte, count int) (*ChainForwardIterator, error) {
+	height, ok := bc.GetMainChainHeight(hash)
+	if !ok {
+		return nil, fmt.Errorf("block %x is not in main chain", hash)
+	}
+
+	end := -1
+	if count >= 0 {
+		end = height + count - 1
+	}
+
+	return bc.ForwardIterator(height, end), nil
+}
+
+//Next 返回下一个区块，到达end或tip时返回nil
+func (fi *ChainForwardIterator) Next() *Block {
+	var block *Block
+
+	if fi.end >= 0 && fi.height > fi.end {
+		return nil
+	}
+
+	err := fi.db.View(func(tx *bolt.Tx) error {
+		hash := tx.Bucket([]byte(heightIndexBucket)).Get(heightKey(fi.height))
+		if hash == nil {
+			return nil
+		}
+
+		block = DeserializeBlock(tx.Bucket([]byte(blocksBucket)).Get(hash))
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if block != nil {
+		fi.height++
+	}
+
+	return block
+}
+
+//GetBlockByHeight 返回主链上高度为height的区块
+func (bc *Chain) GetBlockByHeight(height int) (Block, error) {
+	var block Block
+
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		hash := tx.Bucket([]byte(heightIndexBucket)).Get(heightKey(height))
+		if hash == nil {
+			return errors.New("block is not found")
+		}
+
+		b, err := DecodeBlock(tx.Bucket([]byte(blocksBucket)).Get(hash))
+		if err != nil {
+			return err
+		}
+		block = *b
+
+		return nil
+	})
+
+	return block, err
+}
+
+//GetMainChainHeight 返回区块在主链上的高度，区块不在主链上时返回false
+func (bc *Chain) GetMainChainHeight(hash []byte) (int, bool) {
+	height := 0
+	found := false
+
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		height, found = mainChainHeight(tx, hash)
+
+		return nil
+	})
+	if err != nil {
+		return 0, false
+	}
+
+	return height, found
+}
+
+//mainChainHeight 返回区块在主链上的高度，区块不在主链上时返回false
+func mainChainHeight(tx *bolt.Tx, hash []byte) (int, bool) {
+	data := tx.Bucket([]byte(blocksBucket)).Get(hash)
+	if data == nil {
+		return 0, false
+	}
+
+	b, err := DecodeBlock(data)
+	if err != nil {
+		return 0, false
+	}
+
+	mainHash := tx.Bucket([]byte(heightIndexBucket)).Get(heightKey(b.Height))
+	if !bytes.Equal(mainHash, hash) {
+		return 0, false
+	}
+
+	return b.Height, true
+}
+
+//buildHeightIndex 沿主链从tip往回构建高度索引，用于没有该索引的旧数据库
+func buildHeightIndex(tx *bolt.Tx) error {
+	if tx.Bucket([]byte(heightIndexBucket)) != nil {
+		return nil
+	}
+
+	heights, err := tx.CreateBucket([]byte(heightIndexBucket))
+	if err != nil {
+		return err
+	}
+
+	blocks := tx.Bucket([]byte(blocksBucket))
+	hash := blocks.Get([]byte("l"))
+
+	for len(hash) > 0 {
+		b, err := DecodeBlock(blocks.Get(hash))
+		if err != nil {
+			return err
+		}
+
+		err = heights.Put(heightKey(b.Height), b.Hash)
+		if err != nil {
+			return err
+		}
+
+		hash = b.PrevBlockHash
+	}
+
+	return nil
+}
+
+//heightKey 使用大端序，bucket中的key按高度排列
+func heightKey(height int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(height))
+
+	return key
+}
\ No newline at end of file
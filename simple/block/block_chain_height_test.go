@@ -0,0 +1,42 @@
+package block
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/transaction"
+	"github.com/pylrichard/building_block_chain_in_go/simple/wallet"
+)
+
+func TestForwardIterator(t *testing.T) {
+	addr := string(wallet.NewWallet().GetAddr())
+	bc := NewChainWithGenesis(addr, t.TempDir(), "height_test")
+	defer bc.Db.Close()
+
+	var hashes [][]byte
+	hashes = append(hashes, bc.tip)
+	for height := 1; height <= 2; height++ {
+		b, err := bc.MineBlock([]*transaction.Transaction{transaction.NewCoinBaseTx(addr, "", height, 0)})
+		assert.Nil(t, err)
+		hashes = append(hashes, b.Hash)
+	}
+
+	b, err := bc.GetBlockByHeight(1)
+	assert.Nil(t, err)
+	assert.Equal(t, hashes[1], b.Hash)
+	_, err = bc.GetBlockByHeight(3)
+	assert.NotNil(t, err)
+
+	var visited [][]byte
+	fi := bc.ForwardIterator(0, -1)
+	for b := fi.Next(); b != nil; b = fi.Next() {
+		visited = append(visited, b.Hash)
+	}
+	assert.Equal(t, hashes, visited)
+
+	fi, err = bc.ForwardIteratorFromHash(hashes[1], 1)
+	assert.Nil(t, err)
+	assert.Equal(t, hashes[1], fi.Next().Hash)
+	assert.Nil(t, fi.Next())
+}
\ No newline at end of file
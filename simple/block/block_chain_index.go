@@ -0,0 +1,287 @@
+package block
+
+import (
+	"bytes"
+	"errors"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/codec"
+	"github.com/pylrichard/building_block_chain_in_go/simple/transaction"
+	"github.com/pylrichard/building_block_chain_in_go/simple/wallet"
+)
+
+//交易索引和地址索引只包含主链上的区块，和高度索引一起在切换"l"的同一个bolt事务中更新
+//两个bucket不存在时不维护这两个索引，通过RebuildIndexes创建
+const txIndexBucket = "tx_index"
+const addrIndexBucket = "addr_index"
+
+//errNoIndex 索引不存在或不能用于blockHash所在的分支，需要遍历区块查找
+var errNoIndex = errors.New("transaction index can not be used")
+
+//txLocation 交易在主链上的位置
+type txLocation struct {
+	blockHash	[]byte
+	height		int
+	position	int
+}
+
+//HasIndexes 返回是否启用了交易索引和地址索引
+func (bc *Chain) HasIndexes() bool {
+	enabled := false
+
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		enabled = tx.Bucket([]byte(txIndexBucket)) != nil
+
+		return nil
+	})
+	if err != nil {
+		return false
+	}
+
+	return enabled
+}
+
+//RebuildIndexes 删除并按当前主链重新构建交易索引和地址索引，返回索引的交易数量
+func (bc *Chain) RebuildIndexes() (int, error) {
+	count := 0
+
+	err := bc.Db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{txIndexBucket, addrIndexBucket} {
+			err := tx.DeleteBucket([]byte(name))
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+
+			_, err = tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+		}
+
+		blocks := tx.Bucket([]byte(blocksBucket))
+		hash := blocks.Get([]byte("l"))
+
+		for len(hash) > 0 {
+			b, err := DecodeBlock(blocks.Get(hash))
+			if err != nil {
+				return err
+			}
+
+			err = indexBlock(tx, b)
+			if err != nil {
+				return err
+			}
+
+			count += len(b.Transactions)
+			hash = b.PrevBlockHash
+		}
+
+		return nil
+	})
+
+	return count, err
+}
+
+//FindAddressTransactions 返回主链上输入或输出属于pubKeyHash的交易Id，需要启用索引
+func (bc *Chain) FindAddressTransactions(pubKeyHash []byte) ([][]byte, error) {
+	var txIds [][]byte
+
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(addrIndexBucket))
+		if bucket == nil {
+			return errors.New("address index is not enabled")
+		}
+
+		prefix := addrPrefix(pubKeyHash)
+		c := bucket.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			txIds = append(txIds, append([]byte{}, k[len(prefix):]...))
+		}
+
+		return nil
+	})
+
+	return txIds, err
+}
+
+//findIndexedTransaction 通过交易索引查找blockHash及其祖先中的交易
+//索引只包含主链，blockHash不在主链上时返回errNoIndex由调用者遍历区块
+func (bc *Chain) findIndexedTransaction(blockHash, Id []byte) (transaction.Transaction, int, error) {
+	var result transaction.Transaction
+	var height int
+
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(txIndexBucket))
+		blocks := tx.Bucket([]byte(blocksBucket))
+		if bucket == nil {
+			return errNoIndex
+		}
+
+		startHeight := -1
+		if !bytes.Equal(blocks.Get([]byte("l")), blockHash) {
+			h, ok := mainChainHeight(tx, blockHash)
+			if !ok {
+				return errNoIndex
+			}
+			startHeight = h
+		}
+
+		data := bucket.Get(Id)
+		if data == nil {
+			return errTxNotFound
+		}
+
+		loc, err := decodeTxLocation(data)
+		if err != nil {
+			return err
+		}
+		//交易在blockHash之后的区块中
+		if startHeight >= 0 && loc.height > startHeight {
+			return errTxNotFound
+		}
+
+		b, err := DecodeBlock(blocks.Get(loc.blockHash))
+		if err != nil {
+			return err
+		}
+		if loc.position >= len(b.Transactions) {
+			return errors.New("transaction index is corrupted")
+		}
+
+		result = *b.Transactions[loc.position]
+		height = loc.height
+
+		return nil
+	})
+
+	return result, height, err
+}
+
+//indexBlock 把连接到主链的区块加入索引
+func indexBlock(tx *bolt.Tx, b *Block) error {
+	err := tx.Bucket([]byte(heightIndexBucket)).Put(heightKey(b.Height), b.Hash)
+	if err != nil {
+		return err
+	}
+
+	txIndex := tx.Bucket([]byte(txIndexBucket))
+	addrIndex := tx.Bucket([]byte(addrIndexBucket))
+	if txIndex == nil || addrIndex == nil {
+		return nil
+	}
+
+	for i, t := range b.Transactions {
+		loc := txLocation{b.Hash, b.Height, i}
+		err := txIndex.Put(t.Id, loc.encode())
+		if err != nil {
+			return err
+		}
+
+		for _, pubKeyHash := range addressesOf(t) {
+			err = addrIndex.Put(addrKey(pubKeyHash, t.Id), []byte{})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+//unindexBlock 把从主链断开的区块移出索引
+func unindexBlock(tx *bolt.Tx, b *Block) error {
+	heights := tx.Bucket([]byte(heightIndexBucket))
+	if bytes.Equal(heights.Get(heightKey(b.Height)), b.Hash) {
+		err := heights.Delete(heightKey(b.Height))
+		if err != nil {
+			return err
+		}
+	}
+
+	txIndex := tx.Bucket([]byte(txIndexBucket))
+	addrIndex := tx.Bucket([]byte(addrIndexBucket))
+	if txIndex == nil || addrIndex == nil {
+		return nil
+	}
+
+	for _, t := range b.Transactions {
+		//相同Id的交易已经按其他区块索引时保留索引
+		data := txIndex.Get(t.Id)
+		if data == nil {
+			continue
+		}
+		loc, err := decodeTxLocation(data)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(loc.blockHash, b.Hash) {
+			continue
+		}
+
+		err = txIndex.Delete(t.Id)
+		if err != nil {
+			return err
+		}
+
+		for _, pubKeyHash := range addressesOf(t) {
+			err = addrIndex.Delete(addrKey(pubKeyHash, t.Id))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+//addrPrefix 返回pubKeyHash的地址索引前缀pubKeyHash(varbytes)，长度前缀使较短的pubKeyHash不会匹配较长的
+func addrPrefix(pubKeyHash []byte) []byte {
+	w := codec.NewWriter()
+	w.WriteVarBytes(pubKeyHash)
+
+	return w.Bytes()
+}
+
+//addrKey 地址索引的key为pubKeyHash(varbytes) + txId
+func addrKey(pubKeyHash, txId []byte) []byte {
+	return append(addrPrefix(pubKeyHash), txId...)
+}
+
+//addressesOf 返回交易的输入和输出涉及的pubKeyHash
+func addressesOf(t *transaction.Transaction) [][]byte {
+	var pubKeyHashes [][]byte
+
+	if !t.IsCoinBase() {
+		for _, in := range t.In {
+			pubKeyHashes = append(pubKeyHashes, wallet.HashPubKey(in.PubKey))
+		}
+	}
+
+	for _, out := range t.Out {
+		pubKeyHashes = append(pubKeyHashes, append([]byte{}, out.PubKeyHash...))
+	}
+
+	return pubKeyHashes
+}
+
+//encode 编码为blockHash(varbytes) + height(varint) + position(varint)
+func (loc txLocation) encode() []byte {
+	w := codec.NewWriter()
+	w.WriteVarBytes(loc.blockHash)
+	w.WriteVarInt(uint64(loc.height))
+	w.WriteVarInt(uint64(loc.position))
+
+	return w.Bytes()
+}
+
+func decodeTxLocation(data []byte) (txLocation, error) {
+	var loc txLocation
+
+	r := codec.NewReader(data)
+	loc.blockHash = r.ReadVarBytes()
+	loc.height = int(r.ReadVarInt())
+	loc.position = int(r.ReadVarInt())
+
+	return loc, r.Finish()
+}
\ No newline at end of file
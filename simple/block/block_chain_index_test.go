@@ -0,0 +1,78 @@
+package block
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/transaction"
+	"github.com/pylrichard/building_block_chain_in_go/simple/wallet"
+)
+
+func TestIndexes(t *testing.T) {
+	w := wallet.NewWallet()
+	addr := string(w.GetAddr())
+	bc := NewChainWithGenesis(addr, t.TempDir(), "index_test")
+	defer bc.Db.Close()
+	assert.False(t, bc.HasIndexes())
+
+	count, err := bc.RebuildIndexes()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+
+	cbTx := transaction.NewCoinBaseTx(addr, "", 1, 0)
+	_, err = bc.MineBlock([]*transaction.Transaction{cbTx})
+	assert.Nil(t, err)
+
+	tx, height, err := bc.FindTransactionWithHeight(bc.tip, cbTx.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, height)
+	assert.Equal(t, cbTx.Id, tx.Id)
+
+	_, err = bc.FindTransaction([]byte("missing"))
+	assert.NotNil(t, err)
+
+	txIds, err := bc.FindAddressTransactions(wallet.HashPubKey(w.PublicKey))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(txIds))
+	assert.Contains(t, txIds, cbTx.Id)
+
+	//pubKeyHash的前缀是另一个地址，两者的交易互不包含
+	short := wallet.HashPubKey(w.PublicKey)[:10]
+	shortTx := transaction.NewCoinBaseTx(addr, "", 2, 0)
+	shortTx.Out[0].PubKeyHash = short
+	shortTx.Id = shortTx.Hash()
+	mined, err := bc.MineBlock([]*transaction.Transaction{shortTx})
+	assert.Nil(t, err)
+
+	txIds, err = bc.FindAddressTransactions(short)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{shortTx.Id}, txIds)
+	txIds, err = bc.FindAddressTransactions(wallet.HashPubKey(w.PublicKey))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(txIds))
+
+	//索引指向其他区块时断开区块不删除索引
+	other := *mined
+	other.Hash = []byte("other")
+	err = bc.Db.Update(func(tx *bolt.Tx) error {
+		return unindexBlock(tx, &other)
+	})
+	assert.Nil(t, err)
+	_, height, err = bc.FindTransactionWithHeight(bc.tip, shortTx.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, height)
+	txIds, err = bc.FindAddressTransactions(short)
+	assert.Nil(t, err)
+	assert.Len(t, txIds, 1)
+
+	err = bc.Db.Update(func(tx *bolt.Tx) error {
+		return unindexBlock(tx, mined)
+	})
+	assert.Nil(t, err)
+	txIds, err = bc.FindAddressTransactions(short)
+	assert.Nil(t, err)
+	assert.Empty(t, txIds)
+}
\ No newline at end of file
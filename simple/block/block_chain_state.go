@@ -0,0 +1,276 @@
+package block
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/codec"
+	"github.com/pylrichard/building_block_chain_in_go/simple/transaction"
+)
+
+//ChainStateBucket 保存主链上所有未花费的交易输出，key为交易Id，和"l"在同一个bolt事务中更新
+//utxo.Set读取chainstate，Reindex时按主链重新构建
+const ChainStateBucket = "chainstate"
+//undoBucket 以区块哈希为key保存区块花费的输出，区块从主链断开时用它恢复chainstate
+const undoBucket = "undo"
+
+//spentOutput 被区块中的交易花费的输出，以及它所在交易的高度和是否为Coinbase交易
+type spentOutput struct {
+	txId		[]byte
+	index		int
+	outputs		transaction.TxOutputs
+}
+
+//checkUnspent 父区块为tip时，验证交易花费的输出在chainstate中或由区块中更早的交易创建，且交易Id没有未花费的输出
+//父区块不是tip时chainstate不对应它所在的分支，由connectBlock在切换主链时回滚和重放chainstate后验证
+func (bc *Chain) checkUnspent(txs []*transaction.Transaction, parentHash []byte) error {
+	return bc.Db.View(func(tx *bolt.Tx) error {
+		if !bytes.Equal(tx.Bucket([]byte(blocksBucket)).Get([]byte("l")), parentHash) {
+			return nil
+		}
+
+		state := tx.Bucket([]byte(ChainStateBucket))
+		if state == nil {
+			return nil
+		}
+
+		created := make(map[string]bool)
+		for _, t := range txs {
+			if !t.IsCoinBase() {
+				for _, in := range t.In {
+					if created[hex.EncodeToString(in.TxId)] {
+						continue
+					}
+
+					outsBytes := state.Get(in.TxId)
+					if outsBytes == nil {
+						return fmt.Errorf("%w: %x:%d", ErrMissingOutput, in.TxId, in.Out)
+					}
+					if _, ok := transaction.DeserializeOutputs(outsBytes).Outputs[in.Out]; !ok {
+						return fmt.Errorf("%w: %x:%d", ErrMissingOutput, in.TxId, in.Out)
+					}
+				}
+			}
+
+			if state.Get(t.Id) != nil {
+				return fmt.Errorf("%w: %x", ErrTxIdExists, t.Id)
+			}
+			created[hex.EncodeToString(t.Id)] = true
+		}
+
+		return nil
+	})
+}
+
+//ConnectBlock 把连接到主链的区块应用到chainstate，返回的error和connectBlock相同
+//AddBlock和MineBlock已经在切换tip的bolt事务中更新chainstate，ConnectBlock用于单独应用一个区块
+func (bc *Chain) ConnectBlock(b *Block) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	return bc.Db.Update(func(tx *bolt.Tx) error {
+		return connectBlock(tx, b)
+	})
+}
+
+//ReindexChainState 在一个bolt事务中从创世块开始按主链重放所有区块，重建chainstate和undo数据
+func (bc *Chain) ReindexChainState() error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	return bc.Db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{ChainStateBucket, undoBucket} {
+			err := tx.DeleteBucket([]byte(name))
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+
+		//从tip往回收集主链区块，再从创世块开始连接
+		blocks := tx.Bucket([]byte(blocksBucket))
+		var mainChain []*Block
+		for hash := blocks.Get([]byte("l")); len(hash) > 0; {
+			b, err := DecodeBlock(blocks.Get(hash))
+			if err != nil {
+				return err
+			}
+			mainChain = append(mainChain, b)
+			hash = b.PrevBlockHash
+		}
+
+		for i := len(mainChain) - 1; i >= 0; i-- {
+			err := connectBlock(tx, mainChain[i])
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+//connectBlock 把连接到主链的区块应用到chainstate，并保存它花费的输出
+//区块花费的输出不在chainstate中时返回ErrMissingOutput，交易Id还有未花费的输出时返回ErrTxIdExists
+func connectBlock(tx *bolt.Tx, b *Block) error {
+	state, err := tx.CreateBucketIfNotExists([]byte(ChainStateBucket))
+	if err != nil {
+		return err
+	}
+	undo, err := tx.CreateBucketIfNotExists([]byte(undoBucket))
+	if err != nil {
+		return err
+	}
+
+	var spent []spentOutput
+	for _, t := range b.Transactions {
+		if !t.IsCoinBase() {
+			for _, in := range t.In {
+				outsBytes := state.Get(in.TxId)
+				if outsBytes == nil {
+					return fmt.Errorf("%w: %x:%d", ErrMissingOutput, in.TxId, in.Out)
+				}
+
+				outs := transaction.DeserializeOutputs(outsBytes)
+				out, ok := outs.Outputs[in.Out]
+				if !ok {
+					return fmt.Errorf("%w: %x:%d", ErrMissingOutput, in.TxId, in.Out)
+				}
+
+				spent = append(spent, spentOutput{in.TxId, in.Out, transaction.TxOutputs{
+					Outputs:	map[int]transaction.TxOutput{in.Out: out},
+					Height:		outs.Height,
+					CoinBase:	outs.CoinBase,
+				}})
+
+				delete(outs.Outputs, in.Out)
+				err = putOutputs(state, in.TxId, outs)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		//覆盖同一Id的交易会使它未花费的输出消失，断开区块时也无法恢复
+		if state.Get(t.Id) != nil {
+			return fmt.Errorf("%w: %x", ErrTxIdExists, t.Id)
+		}
+
+		newOutputs := transaction.TxOutputs{
+			Outputs:	make(map[int]transaction.TxOutput),
+			Height:		b.Height,
+			CoinBase:	t.IsCoinBase(),
+		}
+		for outIdx, out := range t.Out {
+			newOutputs.Outputs[outIdx] = out
+		}
+
+		err = state.Put(t.Id, newOutputs.Serialize())
+		if err != nil {
+			return err
+		}
+	}
+
+	return undo.Put(b.Hash, encodeSpentOutputs(spent))
+}
+
+//disconnectBlock 撤销从主链断开的区块对chainstate的修改，区块按从tip往回的顺序断开
+func disconnectBlock(tx *bolt.Tx, b *Block) error {
+	state, err := tx.CreateBucketIfNotExists([]byte(ChainStateBucket))
+	if err != nil {
+		return err
+	}
+	undo, err := tx.CreateBucketIfNotExists([]byte(undoBucket))
+	if err != nil {
+		return err
+	}
+
+	data := undo.Get(b.Hash)
+	if data == nil {
+		return fmt.Errorf("undo data of block %x is not found, run reindex_utxo", b.Hash)
+	}
+	spent, err := decodeSpentOutputs(data)
+	if err != nil {
+		return err
+	}
+
+	//交易按相反的顺序撤销，花费同一区块中更早交易的输出时先恢复再删除
+	for i := len(b.Transactions) - 1; i >= 0; i-- {
+		t := b.Transactions[i]
+
+		err = state.Delete(t.Id)
+		if err != nil {
+			return err
+		}
+
+		if t.IsCoinBase() {
+			continue
+		}
+
+		for j := len(t.In) - 1; j >= 0; j-- {
+			if len(spent) == 0 {
+				return fmt.Errorf("undo data of block %x is incomplete", b.Hash)
+			}
+			s := spent[len(spent) - 1]
+			spent = spent[:len(spent) - 1]
+
+			outs := s.outputs
+			if outsBytes := state.Get(s.txId); outsBytes != nil {
+				outs = transaction.DeserializeOutputs(outsBytes)
+				outs.Outputs[s.index] = s.outputs.Outputs[s.index]
+			}
+
+			err = state.Put(s.txId, outs.Serialize())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return undo.Delete(b.Hash)
+}
+
+//putOutputs 保存交易剩余的未花费输出，全部被花费时删除
+func putOutputs(state *bolt.Bucket, txId []byte, outs transaction.TxOutputs) error {
+	if len(outs.Outputs) == 0 {
+		return state.Delete(txId)
+	}
+
+	return state.Put(txId, outs.Serialize())
+}
+
+//encodeSpentOutputs 按以下格式编码区块花费的输出，顺序和区块中交易的Input相同
+//数量(varint) + (TxId(varbytes) + 索引(4) + TxOutputs(varbytes))...
+func encodeSpentOutputs(spent []spentOutput) []byte {
+	w := codec.NewWriter()
+
+	w.WriteVarInt(uint64(len(spent)))
+	for _, s := range spent {
+		w.WriteVarBytes(s.txId)
+		w.WriteUint32(uint32(s.index))
+		w.WriteVarBytes(s.outputs.Serialize())
+	}
+
+	return w.Bytes()
+}
+
+func decodeSpentOutputs(data []byte) ([]spentOutput, error) {
+	var spent []spentOutput
+	r := codec.NewReader(data)
+
+	num := r.ReadCount(1)
+	for i := 0; i < num; i++ {
+		txId := r.ReadVarBytes()
+		index := int(r.ReadUint32())
+		outsBytes := r.ReadVarBytes()
+		if r.Err() != nil {
+			return nil, r.Err()
+		}
+
+		spent = append(spent, spentOutput{txId, index, transaction.DeserializeOutputs(outsBytes)})
+	}
+
+	return spent, r.Finish()
+}
\ No newline at end of file
@@ -0,0 +1,90 @@
+package block
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/transaction"
+	"github.com/pylrichard/building_block_chain_in_go/simple/wallet"
+)
+
+//newSpendTx 返回w花费prevTx第0个输出、把value支付给to的已签名交易
+func newSpendTx(bc *Chain, w *wallet.Wallet, prevTx *transaction.Transaction, value int, to string) *transaction.Transaction {
+	in := transaction.TxInput{TxId: prevTx.Id, Out: 0, PubKey: w.PublicKey}
+	tx := &transaction.Transaction{In: []transaction.TxInput{in}, Out: []transaction.TxOutput{*transaction.NewTxOutput(value, to)}}
+	tx.Id = tx.Hash()
+	bc.SignTransaction(tx, w.PrivateKey)
+
+	return tx
+}
+
+func TestCheckUnspent(t *testing.T) {
+	w := wallet.NewWallet()
+	addr := string(w.GetAddr())
+	other := string(wallet.NewWallet().GetAddr())
+	bc := NewChainWithGenesis(addr, t.TempDir(), "state_test")
+	defer bc.Db.Close()
+
+	genesis, err := bc.GetBlockByHeight(0)
+	assert.Nil(t, err)
+	genesisCb := genesis.Transactions[0]
+	value := genesisCb.Out[0].Value
+
+	spend := newSpendTx(bc, w, genesisCb, value, other)
+	cbTx := transaction.NewCoinBaseTx(addr, "", 1, 0)
+	_, err = bc.MineBlock([]*transaction.Transaction{cbTx, spend})
+	assert.Nil(t, err)
+
+	//genesis的输出已经在区块1中被花费
+	doubleSpend := newSpendTx(bc, w, genesisCb, value - 1, other)
+	cbTx2 := transaction.NewCoinBaseTx(addr, "", 2, 1)
+	_, err = bc.MineBlock([]*transaction.Transaction{cbTx2, doubleSpend})
+	assert.ErrorIs(t, err, ErrMissingOutput)
+
+	//复制的Coinbase交易会覆盖还未花费的输出
+	_, err = bc.MineBlock([]*transaction.Transaction{cbTx})
+	assert.ErrorIs(t, err, ErrTxIdExists)
+	assert.Equal(t, 1, bc.GetBestHeight())
+}
+
+func TestReindexChainState(t *testing.T) {
+	w := wallet.NewWallet()
+	addr := string(w.GetAddr())
+	other := string(wallet.NewWallet().GetAddr())
+	bc := NewChainWithGenesis(addr, t.TempDir(), "state_test")
+	defer bc.Db.Close()
+
+	genesis, err := bc.GetBlockByHeight(0)
+	assert.Nil(t, err)
+	genesisCb := genesis.Transactions[0]
+
+	spend := newSpendTx(bc, w, genesisCb, genesisCb.Out[0].Value, other)
+	_, err = bc.MineBlock([]*transaction.Transaction{transaction.NewCoinBaseTx(addr, "", 1, 0), spend})
+	assert.Nil(t, err)
+
+	//删除chainstate和undo数据后重建
+	err = bc.Db.Update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket([]byte(ChainStateBucket))
+		if err != nil {
+			return err
+		}
+
+		return tx.DeleteBucket([]byte(undoBucket))
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, bc.ReindexChainState())
+	assert.False(t, hasUnspent(t, bc, genesisCb.Id))
+	assert.True(t, hasUnspent(t, bc, spend.Id))
+
+	//重建的undo数据可以用于断开区块
+	for _, b := range mineBranch(t, other, &genesis, 2) {
+		_, err = bc.AddBlock(b)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 2, bc.GetBestHeight())
+	assert.True(t, hasUnspent(t, bc, genesisCb.Id))
+	assert.False(t, hasUnspent(t, bc, spend.Id))
+}
\ No newline at end of file
@@ -0,0 +1,217 @@
+package block
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/codec"
+)
+
+//blockVersion 区块编码格式的版本
+const blockVersion = 1
+//locatorDenseNum 区块定位器中从tip开始逐个加入的区块数，之后间隔加倍
+const locatorDenseNum = 10
+
+//BlockHeader 区块中参与PoW计算的部分，headers-first同步时先下载和验证区块头
+type BlockHeader struct {
+	Timestamp		int64
+	PrevBlockHash	[]byte
+	MerkleRoot		[]byte
+	Hash			[]byte
+	Nonce			int
+	Height			int
+	Bits			int
+}
+
+//headerLookup 根据哈希返回区块头，用于验证还没有保存的区块头
+type headerLookup func(hash []byte) (BlockHeader, error)
+
+func (b *Block) Header() BlockHeader {
+	return BlockHeader{b.Timestamp, b.PrevBlockHash, b.MerkleRoot, b.Hash, b.Nonce, b.Height, b.Bits}
+}
+
+//toBlock 返回不包含交易的区块，用于计算PoW
+func (h BlockHeader) toBlock() *Block {
+	return &Block{h.Timestamp, nil, h.PrevBlockHash, h.MerkleRoot, h.Hash, h.Nonce, h.Height, h.Bits}
+}
+
+//Serialize 按以下格式编码区块头，Hash不写入，解析时重新计算
+//version(4) + Timestamp(8) + PrevBlockHash(varbytes) + MerkleRoot(varbytes) + Nonce(8) + Height(4) + Bits(4)
+func (h BlockHeader) Serialize() []byte {
+	w := codec.NewWriter()
+	h.write(w)
+
+	return w.Bytes()
+}
+
+func (h BlockHeader) write(w *codec.Writer) {
+	w.WriteUint32(blockVersion)
+	w.WriteInt64(h.Timestamp)
+	w.WriteVarBytes(h.PrevBlockHash)
+	w.WriteVarBytes(h.MerkleRoot)
+	w.WriteInt64(int64(h.Nonce))
+	w.WriteUint32(uint32(h.Height))
+	w.WriteUint32(uint32(h.Bits))
+}
+
+//DecodeHeader 解析其他节点发送的区块头
+func DecodeHeader(data []byte) (BlockHeader, error) {
+	r := codec.NewReader(data)
+
+	h, err := readHeader(r)
+	if err != nil {
+		return h, err
+	}
+
+	return h, r.Finish()
+}
+
+func readHeader(r *codec.Reader) (BlockHeader, error) {
+	var h BlockHeader
+
+	if v := r.ReadUint32(); r.Err() == nil && v != blockVersion {
+		return h, fmt.Errorf("unknown block version %d", v)
+	}
+	h.Timestamp = r.ReadInt64()
+	h.PrevBlockHash = r.ReadVarBytes()
+	h.MerkleRoot = r.ReadVarBytes()
+	h.Nonce = int(r.ReadInt64())
+	h.Height = int(r.ReadUint32())
+	h.Bits = int(r.ReadUint32())
+	if r.Err() != nil {
+		return BlockHeader{}, r.Err()
+	}
+	//计算哈希时需要用Bits创建ProofOfWork，超出范围的Bits不能用于计算target
+	if h.Bits < minTargetBits || h.Bits > maxTargetBits {
+		return BlockHeader{}, fmt.Errorf("block bits %d is out of range", h.Bits)
+	}
+
+	hash := sha256.Sum256(NewProofOfWork(h.toBlock()).prepareData(h.Nonce))
+	h.Hash = hash[:]
+
+	return h, nil
+}
+
+//checkHeaderSanity 验证区块头的哈希、PoW和时间上限
+func checkHeaderSanity(h BlockHeader) error {
+	pow := NewProofOfWork(h.toBlock())
+	hash := sha256.Sum256(pow.prepareData(h.Nonce))
+	if !bytes.Equal(h.Hash, hash[:]) {
+		return ErrInvalidHash
+	}
+	if !pow.Validate() {
+		return ErrInvalidPoW
+	}
+
+	if h.Timestamp > time.Now().Unix() + maxFutureBlockTime {
+		return ErrTimeTooNew
+	}
+
+	return nil
+}
+
+//checkHeaderContext 验证依赖父区块所在分支的规则：高度、难度和median-time-past
+func checkHeaderContext(h, parent BlockHeader, lookup headerLookup) error {
+	if h.Height != parent.Height + 1 {
+		return ErrInvalidHeight
+	}
+
+	bits, err := nextBits(parent, lookup)
+	if err != nil {
+		return err
+	}
+	if h.Bits != bits {
+		return ErrInvalidDifficulty
+	}
+
+	mtp, err := medianTimePast(parent, lookup)
+	if err != nil {
+		return err
+	}
+	if h.Timestamp <= mtp {
+		return ErrTimeTooOld
+	}
+
+	return nil
+}
+
+//getHeader 返回已经保存的区块的区块头
+func (bc *Chain) getHeader(hash []byte) (BlockHeader, error) {
+	b, err := bc.GetBlock(hash)
+	if err != nil {
+		return BlockHeader{}, err
+	}
+
+	return b.Header(), nil
+}
+
+//HasBlock 返回区块是否已经保存，包括不在主链上的区块
+func (bc *Chain) HasBlock(hash []byte) bool {
+	found := false
+
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket([]byte(blocksBucket)).Get(hash) != nil
+
+		return nil
+	})
+
+	return err == nil && found
+}
+
+//GetBlockLocator 返回主链上从tip往回的区块哈希，前locatorDenseNum个逐个选择，之后间隔加倍，最后是创世块
+//对方节点用它找到双方主链的分叉点
+func (bc *Chain) GetBlockLocator() [][]byte {
+	var locator [][]byte
+
+	step := 1
+	height := bc.GetBestHeight()
+
+	for height > 0 {
+		b, err := bc.GetBlockByHeight(height)
+		if err != nil {
+			break
+		}
+		locator = append(locator, b.Hash)
+
+		if len(locator) >= locatorDenseNum {
+			step *= 2
+		}
+		height -= step
+	}
+
+	genesis, err := bc.GetBlockByHeight(0)
+	if err == nil {
+		locator = append(locator, genesis.Hash)
+	}
+
+	return locator
+}
+
+//GetHeadersAfter 找到locator中第一个位于主链上的区块，返回它之后最多max个主链区块头，遇到stopHash时停止
+//locator中的区块都不在主链上时从创世块之后开始
+func (bc *Chain) GetHeadersAfter(locator [][]byte, stopHash []byte, max int) []BlockHeader {
+	var headers []BlockHeader
+
+	start := 1
+	for _, hash := range locator {
+		if height, ok := bc.GetMainChainHeight(hash); ok {
+			start = height + 1
+			break
+		}
+	}
+
+	fi := bc.ForwardIterator(start, start + max - 1)
+	for b := fi.Next(); b != nil; b = fi.Next() {
+		headers = append(headers, b.Header())
+
+		if bytes.Equal(b.Hash, stopHash) {
+			break
+		}
+	}
+
+	return headers
+}
\ No newline at end of file
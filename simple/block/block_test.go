@@ -0,0 +1,58 @@
+package block
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/transaction"
+	"github.com/pylrichard/building_block_chain_in_go/simple/wallet"
+)
+
+func TestBlockSerialize(t *testing.T) {
+	addr := string(wallet.NewWallet().GetAddr())
+	cbTx := transaction.NewCoinBaseTx(addr, "", 1, 0)
+	b := NewBlock([]*transaction.Transaction{cbTx}, []byte{1, 2, 3}, 1, minTargetBits)
+
+	decoded, err := DecodeBlock(b.Serialize())
+	assert.Nil(t, err)
+	assert.Equal(t, b.Hash, decoded.Hash)
+	assert.Equal(t, b.Serialize(), decoded.Serialize())
+	assert.Nil(t, checkBlockSanity(decoded))
+
+	_, err = DecodeBlock(b.Serialize()[:10])
+	assert.NotNil(t, err)
+
+	//超出范围的Bits返回error而不是在计算target时panic
+	for _, bits := range []int{minTargetBits - 1, maxTargetBits + 1, 300} {
+		h := b.Header()
+		h.Bits = bits
+		_, err = DecodeHeader(h.Serialize())
+		assert.NotNil(t, err)
+
+		bad := *b
+		bad.Bits = bits
+		_, err = DecodeBlock(bad.Serialize())
+		assert.NotNil(t, err)
+	}
+}
+
+func TestMerkleProofLeaf(t *testing.T) {
+	w := wallet.NewWallet()
+	cbTx := transaction.NewCoinBaseTx(string(w.GetAddr()), "", 1, 0)
+	in := transaction.TxInput{TxId: cbTx.Id, Out: 0, Signature: []byte{1}, PubKey: w.PublicKey}
+	tx := &transaction.Transaction{In: []transaction.TxInput{in}, Out: cbTx.Out}
+	tx.Id = tx.Hash()
+	b := NewBlock([]*transaction.Transaction{cbTx, tx}, []byte{1, 2, 3}, 1, minTargetBits)
+
+	proof, err := b.GetMerkleProof(tx.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, tx.MerkleLeaf(), proof.Leaf)
+	assert.NotEqual(t, tx.Id, proof.Leaf)
+	assert.True(t, proof.Verify(b.MerkleRoot))
+
+	//修改签名不改变交易Id，但改变Merkle根
+	tx.In[0].Signature = []byte{2}
+	assert.Equal(t, tx.Hash(), tx.Id)
+	assert.NotEqual(t, b.MerkleRoot, b.HashTransaction())
+}
\ No newline at end of file
@@ -0,0 +1,146 @@
+package block
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/transaction"
+)
+
+//maxFutureBlockTime 区块时间最多可以比本地时间晚的秒数
+const maxFutureBlockTime = 2 * 60 * 60
+//medianTimeBlocks 计算median-time-past使用的区块数
+const medianTimeBlocks = 11
+
+var (
+	ErrInvalidHash			= errors.New("block hash does not match its header")
+	ErrTimeTooOld			= errors.New("block timestamp is not after median time past")
+	ErrTimeTooNew			= errors.New("block timestamp is too far in the future")
+	ErrNoTransactions		= errors.New("block contains no transactions")
+	ErrFirstTxNotCoinBase	= errors.New("first transaction in block is not coinbase")
+	ErrMultipleCoinBases	= errors.New("block contains more than one coinbase")
+	ErrDuplicateTx			= errors.New("block contains duplicate transactions")
+	ErrDoubleSpend			= errors.New("block contains transactions spending the same output")
+)
+
+//ValidateBlock 验证区块的全部规则，父区块必须已经保存
+//返回的error可以用errors.Is和本包的Err变量比较
+func (bc *Chain) ValidateBlock(b *Block) error {
+	err := checkBlockSanity(b)
+	if err != nil {
+		return err
+	}
+
+	parent, err := bc.GetBlock(b.PrevBlockHash)
+	if err != nil {
+		return err
+	}
+
+	return bc.checkBlockContext(b, &parent)
+}
+
+//checkBlockSanity 验证不依赖其他区块的规则，父区块未知的孤块也要先通过这些检查
+func checkBlockSanity(b *Block) error {
+	err := checkHeaderSanity(b.Header())
+	if err != nil {
+		return err
+	}
+
+	err = checkTransactionList(b.Transactions)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(b.MerkleRoot, b.HashTransaction()) {
+		return ErrInvalidMerkleRoot
+	}
+
+	return nil
+}
+
+//checkBlockContext 验证依赖父区块所在分支的规则：高度、难度、时间和交易
+func (bc *Chain) checkBlockContext(b, parent *Block) error {
+	err := checkHeaderContext(b.Header(), parent.Header(), bc.getHeader)
+	if err != nil {
+		return err
+	}
+
+	return bc.checkTransactions(b.Transactions, b.PrevBlockHash)
+}
+
+//checkTransactionList 验证区块中交易的结构：第一笔且只有第一笔是Coinbase，交易不重复，输出不被重复花费
+func checkTransactionList(txs []*transaction.Transaction) error {
+	if len(txs) == 0 {
+		return ErrNoTransactions
+	}
+
+	seenTxs := make(map[string]bool)
+	spent := make(map[string]bool)
+
+	for i, tx := range txs {
+		if tx == nil {
+			return ErrInvalidTransaction
+		}
+
+		if i == 0 && !tx.IsCoinBase() {
+			return ErrFirstTxNotCoinBase
+		}
+		if i > 0 && tx.IsCoinBase() {
+			return ErrMultipleCoinBases
+		}
+
+		if !bytes.Equal(tx.Id, tx.Hash()) {
+			return fmt.Errorf("%w: transaction %x id does not match its content", ErrInvalidTransaction, tx.Id)
+		}
+
+		txId := hex.EncodeToString(tx.Id)
+		if seenTxs[txId] {
+			return fmt.Errorf("%w: %s", ErrDuplicateTx, txId)
+		}
+		seenTxs[txId] = true
+
+		if len(tx.Out) == 0 {
+			return fmt.Errorf("%w: transaction %s has no outputs", ErrInvalidTransaction, txId)
+		}
+		if tx.IsCoinBase() {
+			continue
+		}
+
+		for _, in := range tx.In {
+			key := fmt.Sprintf("%x:%d", in.TxId, in.Out)
+			if spent[key] {
+				return fmt.Errorf("%w: %s", ErrDoubleSpend, key)
+			}
+			spent[key] = true
+		}
+	}
+
+	return nil
+}
+
+//medianTimePast 返回以h结尾的medianTimeBlocks个区块时间的中位数
+func medianTimePast(h BlockHeader, lookup headerLookup) (int64, error) {
+	var timestamps []int64
+
+	for i := 0; i < medianTimeBlocks; i++ {
+		timestamps = append(timestamps, h.Timestamp)
+		if len(h.PrevBlockHash) == 0 {
+			break
+		}
+
+		prev, err := lookup(h.PrevBlockHash)
+		if err != nil {
+			return 0, err
+		}
+		h = prev
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i] < timestamps[j]
+	})
+
+	return timestamps[len(timestamps) / 2], nil
+}
\ No newline at end of file
@@ -0,0 +1,28 @@
+package block
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/transaction"
+	"github.com/pylrichard/building_block_chain_in_go/simple/wallet"
+)
+
+func TestCheckTransactionList(t *testing.T) {
+	addr := string(wallet.NewWallet().GetAddr())
+	cbTx := transaction.NewCoinBaseTx(addr, "", 1, 0)
+	in := transaction.TxInput{TxId: cbTx.Id, Out: 0}
+	tx := &transaction.Transaction{In: []transaction.TxInput{in}, Out: cbTx.Out}
+	tx.Id = tx.Hash()
+	out := transaction.NewTxOutput(1, addr)
+	conflict := &transaction.Transaction{In: []transaction.TxInput{in}, Out: []transaction.TxOutput{*out}}
+	conflict.Id = conflict.Hash()
+
+	assert.Nil(t, checkTransactionList([]*transaction.Transaction{cbTx, tx}))
+	assert.ErrorIs(t, checkTransactionList(nil), ErrNoTransactions)
+	assert.ErrorIs(t, checkTransactionList([]*transaction.Transaction{tx, cbTx}), ErrFirstTxNotCoinBase)
+	assert.ErrorIs(t, checkTransactionList([]*transaction.Transaction{cbTx, cbTx}), ErrMultipleCoinBases)
+	assert.ErrorIs(t, checkTransactionList([]*transaction.Transaction{cbTx, tx, tx}), ErrDuplicateTx)
+	assert.ErrorIs(t, checkTransactionList([]*transaction.Transaction{cbTx, tx, conflict}), ErrDoubleSpend)
+}
\ No newline at end of file
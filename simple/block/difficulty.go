@@ -0,0 +1,51 @@
+package block
+
+import "fmt"
+
+//retargetInterval 每隔多少个区块调整一次难度
+const retargetInterval = 10
+//targetBlockSpacing 期望的出块间隔，单位为秒
+const targetBlockSpacing = 10
+
+//GetNextBits 根据难度调整规则返回parent之后一个区块的难度
+//高度为retargetInterval的整数倍时，比较上一个周期实际和期望的出块时间：
+//实际时间不到期望的一半时难度加1，超过期望的两倍时难度减1，其他高度沿用parent的难度
+func (bc *Chain) GetNextBits(parent *Block) (int, error) {
+	return nextBits(parent.Header(), bc.getHeader)
+}
+
+//nextBits 通过lookup查找祖先区块头，parent所在分支可以还没有保存
+func nextBits(parent BlockHeader, lookup headerLookup) (int, error) {
+	height := parent.Height + 1
+	if height % retargetInterval != 0 {
+		return parent.Bits, nil
+	}
+
+	first := parent
+	for first.Height > height - retargetInterval {
+		prev, err := lookup(first.PrevBlockHash)
+		if err != nil {
+			return 0, fmt.Errorf("retarget block at height %d is not found: %s", first.Height - 1, err)
+		}
+		first = prev
+	}
+
+	actual := parent.Timestamp - first.Timestamp
+	expected := int64((retargetInterval - 1) * targetBlockSpacing)
+
+	bits := parent.Bits
+	if actual < expected / 2 {
+		bits++
+	} else if actual > expected * 2 {
+		bits--
+	}
+
+	if bits < minTargetBits {
+		bits = minTargetBits
+	}
+	if bits > maxTargetBits {
+		bits = maxTargetBits
+	}
+
+	return bits, nil
+}
\ No newline at end of file
@@ -0,0 +1,78 @@
+package block
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//headerRange 返回高度从0到tip、出块间隔为spacing秒、难度为bits的区块头，以及按哈希查找它们的lookup
+func headerRange(tip int, spacing int64, bits int) ([]BlockHeader, headerLookup) {
+	headers := make([]BlockHeader, tip + 1)
+	byHash := make(map[string]BlockHeader)
+
+	for height := 0; height <= tip; height++ {
+		h := BlockHeader{Timestamp: 1000 + int64(height) * spacing, Height: height, Bits: bits}
+		h.Hash = []byte(fmt.Sprintf("block-%d", height))
+		if height > 0 {
+			h.PrevBlockHash = headers[height - 1].Hash
+		}
+		headers[height] = h
+		byHash[string(h.Hash)] = h
+	}
+
+	lookup := func(hash []byte) (BlockHeader, error) {
+		h, ok := byHash[string(hash)]
+		if !ok {
+			return BlockHeader{}, errors.New("not found")
+		}
+
+		return h, nil
+	}
+
+	return headers, lookup
+}
+
+//retarget 返回高度为0到tip的区块头之后一个区块的难度
+func retarget(tip int, spacing int64, bits int) (int, error) {
+	headers, lookup := headerRange(tip, spacing, bits)
+
+	return nextBits(headers[tip], lookup)
+}
+
+func TestNextBits(t *testing.T) {
+	bits, err := retarget(retargetInterval - 2, 1, 16)
+	assert.Nil(t, err)
+	assert.Equal(t, 16, bits)
+
+	//上一个周期的出块时间不到期望的一半时难度加1，超过两倍时减1
+	bits, err = retarget(retargetInterval - 1, targetBlockSpacing / 4, 16)
+	assert.Nil(t, err)
+	assert.Equal(t, 17, bits)
+	bits, err = retarget(retargetInterval - 1, targetBlockSpacing * 3, 16)
+	assert.Nil(t, err)
+	assert.Equal(t, 15, bits)
+	bits, err = retarget(retargetInterval - 1, targetBlockSpacing / 2, 16)
+	assert.Nil(t, err)
+	assert.Equal(t, 16, bits)
+	bits, err = retarget(retargetInterval * 2 - 1, targetBlockSpacing, 16)
+	assert.Nil(t, err)
+	assert.Equal(t, 16, bits)
+
+	//难度不超出范围
+	bits, err = retarget(retargetInterval - 1, targetBlockSpacing * 3, minTargetBits)
+	assert.Nil(t, err)
+	assert.Equal(t, minTargetBits, bits)
+	bits, err = retarget(retargetInterval - 1, 0, maxTargetBits)
+	assert.Nil(t, err)
+	assert.Equal(t, maxTargetBits, bits)
+
+	//周期中的第一个区块找不到时无法调整难度
+	headers, _ := headerRange(retargetInterval - 1, targetBlockSpacing, 16)
+	_, err = nextBits(headers[retargetInterval - 1], func(hash []byte) (BlockHeader, error) {
+		return BlockHeader{}, errors.New("not found")
+	})
+	assert.NotNil(t, err)
+}
\ No newline at end of file
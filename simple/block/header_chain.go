@@ -0,0 +1,216 @@
+package block
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+//maxPendingHeaders 最多保存的等待下载区块体的区块头数量
+const maxPendingHeaders = 20000
+
+var ErrUnconnectedHeaders = errors.New("headers do not connect to known chain")
+
+//HeaderChain 保存已经验证但区块体还没有保存的区块头，按高度从低到高排列
+//第一个区块头的父区块已经保存，区块体按顺序保存后通过Prune移除，调用者需要自行同步
+type HeaderChain struct {
+	bc		*Chain
+	headers	map[string]BlockHeader
+	order	[][]byte
+}
+
+func (bc *Chain) NewHeaderChain() *HeaderChain {
+	return &HeaderChain{bc: bc, headers: make(map[string]BlockHeader)}
+}
+
+func (hc *HeaderChain) Len() int {
+	return len(hc.order)
+}
+
+func (hc *HeaderChain) Has(hash []byte) bool {
+	_, ok := hc.headers[hex.EncodeToString(hash)]
+
+	return ok
+}
+
+func (hc *HeaderChain) Get(hash []byte) (BlockHeader, bool) {
+	h, ok := hc.headers[hex.EncodeToString(hash)]
+
+	return h, ok
+}
+
+//TipHeight 返回最后一个区块头的高度，没有区块头时返回主链的高度
+func (hc *HeaderChain) TipHeight() int {
+	if len(hc.order) == 0 {
+		return hc.bc.GetBestHeight()
+	}
+
+	h, _ := hc.Get(hc.order[len(hc.order) - 1])
+
+	return h.Height
+}
+
+//Locator 返回从最后一个区块头开始的区块定位器，用于请求后续的区块头
+func (hc *HeaderChain) Locator() [][]byte {
+	var locator [][]byte
+
+	if len(hc.order) > 0 {
+		locator = append(locator, hc.order[len(hc.order) - 1])
+	}
+
+	return append(locator, hc.bc.GetBlockLocator()...)
+}
+
+//Add 验证并加入其他节点发送的连续区块头，返回新加入的区块头哈希
+//区块头从已知区块分叉时，只有新分支的累计工作量比当前等待下载的分支更多时才切换
+func (hc *HeaderChain) Add(headers []BlockHeader) ([][]byte, error) {
+	for i := 1; i < len(headers); i++ {
+		if !bytes.Equal(headers[i].PrevBlockHash, headers[i - 1].Hash) {
+			return nil, fmt.Errorf("%w: header %x is not continuous", ErrUnconnectedHeaders, headers[i].Hash)
+		}
+	}
+
+	//跳过已经知道的区块头
+	for len(headers) > 0 && (hc.Has(headers[0].Hash) || hc.bc.HasBlock(headers[0].Hash)) {
+		headers = headers[1:]
+	}
+	if len(headers) == 0 {
+		return nil, nil
+	}
+
+	parent, err := hc.lookup(headers[0].PrevBlockHash)
+	if err != nil {
+		return nil, fmt.Errorf("%w: parent %x is unknown", ErrUnconnectedHeaders, headers[0].PrevBlockHash)
+	}
+
+	for _, h := range headers {
+		err := checkHeaderSanity(h)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	//和AddBlock选择主链相同，比较累计工作量而不是高度，较少的高难度区块可以超过大量低难度区块
+	work := hc.chainWork(parent.Hash)
+	for _, h := range headers {
+		work.Add(work, NewProofOfWork(h.toBlock()).Work())
+	}
+	if work.Cmp(hc.chainWork(hc.tipHash())) <= 0 {
+		return nil, nil
+	}
+
+	//新分支的父区块不是最后一个区块头时，丢弃父区块之后的区块头
+	forkAt := 0
+	for i, hash := range hc.order {
+		if bytes.Equal(hash, parent.Hash) {
+			forkAt = i + 1
+			break
+		}
+	}
+	for _, hash := range hc.order[forkAt:] {
+		delete(hc.headers, hex.EncodeToString(hash))
+	}
+	hc.order = hc.order[:forkAt]
+
+	var added [][]byte
+
+	for _, h := range headers {
+		if len(hc.order) >= maxPendingHeaders {
+			break
+		}
+
+		err := checkHeaderContext(h, parent, hc.lookup)
+		if err != nil {
+			return added, err
+		}
+
+		hc.headers[hex.EncodeToString(h.Hash)] = h
+		hc.order = append(hc.order, h.Hash)
+		added = append(added, h.Hash)
+		parent = h
+	}
+
+	return added, nil
+}
+
+//Missing 返回前window个区块头中区块体还没有保存也不在孤块池中的哈希
+func (hc *HeaderChain) Missing(window int) [][]byte {
+	var missing [][]byte
+
+	for i, hash := range hc.order {
+		if i >= window {
+			break
+		}
+
+		h, _ := hc.Get(hash)
+		if !hc.bc.HasBlock(hash) && !hc.bc.hasOrphan(h) {
+			missing = append(missing, hash)
+		}
+	}
+
+	return missing
+}
+
+//Prune 移除区块体已经保存的区块头
+func (hc *HeaderChain) Prune() {
+	for len(hc.order) > 0 && hc.bc.HasBlock(hc.order[0]) {
+		delete(hc.headers, hex.EncodeToString(hc.order[0]))
+		hc.order = hc.order[1:]
+	}
+}
+
+func (hc *HeaderChain) Reset() {
+	hc.headers = make(map[string]BlockHeader)
+	hc.order = nil
+}
+
+//tipHash 返回最后一个区块头的哈希，没有区块头时返回主链tip的哈希
+func (hc *HeaderChain) tipHash() []byte {
+	if len(hc.order) > 0 {
+		return hc.order[len(hc.order) - 1]
+	}
+
+	var hash []byte
+	hc.bc.Db.View(func(tx *bolt.Tx) error {
+		hash = tx.Bucket([]byte(blocksBucket)).Get([]byte("l"))
+
+		return nil
+	})
+
+	return hash
+}
+
+//chainWork 返回从创世块到hash的累计工作量，等待下载的区块头沿PrevBlockHash往回累加到已经保存的区块
+func (hc *HeaderChain) chainWork(hash []byte) *big.Int {
+	total := big.NewInt(0)
+
+	for {
+		h, ok := hc.Get(hash)
+		if !ok {
+			break
+		}
+		total.Add(total, NewProofOfWork(h.toBlock()).Work())
+		hash = h.PrevBlockHash
+	}
+
+	hc.bc.Db.View(func(tx *bolt.Tx) error {
+		total.Add(total, getChainWork(tx, hash))
+
+		return nil
+	})
+
+	return total
+}
+
+//lookup 先查找等待下载的区块头，再查找已经保存的区块
+func (hc *HeaderChain) lookup(hash []byte) (BlockHeader, error) {
+	if h, ok := hc.Get(hash); ok {
+		return h, nil
+	}
+
+	return hc.bc.getHeader(hash)
+}
\ No newline at end of file
@@ -0,0 +1,90 @@
+package block
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/transaction"
+	"github.com/pylrichard/building_block_chain_in_go/simple/wallet"
+)
+
+//mineBranch 在parent之后挖出count个不保存的区块
+func mineBranch(t *testing.T, addr string, parent *Block, count int) []*Block {
+	var blocks []*Block
+
+	for i := 0; i < count; i++ {
+		cbTx := transaction.NewCoinBaseTx(addr, "", parent.Height + 1, 0)
+		b, err := newBlockContext(context.Background(), []*transaction.Transaction{cbTx},
+			parent.Hash, parent.Height + 1, parent.Bits, parent.Timestamp + 1)
+		assert.Nil(t, err)
+
+		blocks = append(blocks, b)
+		parent = b
+	}
+
+	return blocks
+}
+
+func headersOf(blocks []*Block) []BlockHeader {
+	var headers []BlockHeader
+	for _, b := range blocks {
+		headers = append(headers, b.Header())
+	}
+
+	return headers
+}
+
+func TestHeaderChain(t *testing.T) {
+	addr := string(wallet.NewWallet().GetAddr())
+	bc := NewChainWithGenesis(addr, t.TempDir(), "header_test")
+	defer bc.Db.Close()
+
+	genesis, err := bc.GetBlockByHeight(0)
+	assert.Nil(t, err)
+
+	branch := mineBranch(t, addr, &genesis, 3)
+	h, err := DecodeHeader(branch[0].Header().Serialize())
+	assert.Nil(t, err)
+	assert.Equal(t, branch[0].Hash, h.Hash)
+
+	hc := bc.NewHeaderChain()
+	_, err = hc.Add(headersOf(branch[1:]))
+	assert.ErrorIs(t, err, ErrUnconnectedHeaders)
+
+	added, err := hc.Add(headersOf(branch))
+	assert.Nil(t, err)
+	assert.Len(t, added, 3)
+	assert.Equal(t, branch[2].Hash, hc.Locator()[0])
+	assert.Equal(t, 3, len(hc.Missing(10)))
+	work := NewProofOfWork(&genesis).Work()
+	work.Mul(work, big.NewInt(4))
+	assert.Equal(t, 0, work.Cmp(hc.chainWork(branch[2].Hash)))
+
+	added, err = hc.Add(headersOf(branch))
+	assert.Nil(t, err)
+	assert.Len(t, added, 0)
+
+	//工作量更少的分支不会替换等待下载的区块头
+	fork := mineBranch(t, addr, &genesis, 4)
+	added, err = hc.Add(headersOf(fork[:2]))
+	assert.Nil(t, err)
+	assert.Len(t, added, 0)
+
+	added, err = hc.Add(headersOf(fork))
+	assert.Nil(t, err)
+	assert.Len(t, added, 4)
+	assert.False(t, hc.Has(branch[0].Hash))
+
+	_, err = bc.AddBlock(fork[0])
+	assert.Nil(t, err)
+	hc.Prune()
+	assert.Equal(t, 3, hc.Len())
+
+	headers := bc.GetHeadersAfter([][]byte{genesis.Hash}, nil, 10)
+	assert.Len(t, headers, 1)
+	assert.Equal(t, fork[0].Hash, headers[0].Hash)
+	assert.Equal(t, [][]byte{fork[0].Hash, genesis.Hash}, bc.GetBlockLocator())
+}
\ No newline at end of file
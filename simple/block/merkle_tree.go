@@ -0,0 +1,105 @@
+package block
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+)
+
+//MerkleTree 保存每一层节点的哈希，levels[0]为叶子，最后一层只有根
+//叶子为数据的SHA-256，父节点为SHA-256(left || right)，节点数为奇数时复制最后一个节点
+type MerkleTree struct {
+	levels [][][]byte
+}
+
+//MerkleProof 证明某个叶子包含在Merkle根中，Siblings按从叶子到根的顺序排列
+//区块中交易的叶子是transaction.MerkleLeaf，包含签名，与交易Id不同
+type MerkleProof struct {
+	Index		int
+	Leaf		[]byte
+	Siblings	[][]byte
+}
+
+func NewMerkleTree(data [][]byte) *MerkleTree {
+	var leaves [][]byte
+
+	for _, datum := range data {
+		hash := sha256.Sum256(datum)
+		leaves = append(leaves, hash[:])
+	}
+
+	tree := &MerkleTree{[][][]byte{leaves}}
+	if len(leaves) == 0 {
+		return tree
+	}
+
+	for level := leaves; len(level) > 1; {
+		var parents [][]byte
+
+		for i := 0; i < len(level); i += 2 {
+			right := level[i]
+			if i + 1 < len(level) {
+				right = level[i + 1]
+			}
+			parents = append(parents, hashPair(level[i], right))
+		}
+
+		tree.levels = append(tree.levels, parents)
+		level = parents
+	}
+
+	return tree
+}
+
+//Root 返回Merkle根，没有数据时返回nil
+func (t *MerkleTree) Root() []byte {
+	top := t.levels[len(t.levels) - 1]
+	if len(top) == 0 {
+		return nil
+	}
+
+	return top[0]
+}
+
+//Proof 生成第index个叶子的包含证明
+func (t *MerkleTree) Proof(index int) (*MerkleProof, error) {
+	if index < 0 || index >= len(t.levels[0]) {
+		return nil, errors.New("merkle leaf index is out of range")
+	}
+
+	proof := &MerkleProof{Index: index, Leaf: t.levels[0][index]}
+
+	for _, level := range t.levels[:len(t.levels) - 1] {
+		sibling := index ^ 1
+		if sibling >= len(level) {
+			sibling = index
+		}
+		proof.Siblings = append(proof.Siblings, level[sibling])
+		index /= 2
+	}
+
+	return proof, nil
+}
+
+//Verify 沿Siblings从叶子计算到根，检查结果是否等于root
+func (p *MerkleProof) Verify(root []byte) bool {
+	hash := p.Leaf
+	index := p.Index
+
+	for _, sibling := range p.Siblings {
+		if index % 2 == 0 {
+			hash = hashPair(hash, sibling)
+		} else {
+			hash = hashPair(sibling, hash)
+		}
+		index /= 2
+	}
+
+	return index == 0 && bytes.Equal(hash, root)
+}
+
+func hashPair(left, right []byte) []byte {
+	hash := sha256.Sum256(append(append([]byte{}, left...), right...))
+
+	return hash[:]
+}
\ No newline at end of file
@@ -0,0 +1,37 @@
+package block
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerkleTree(t *testing.T) {
+	data := [][]byte{[]byte("tx0"), []byte("tx1"), []byte("tx2")}
+	tree := NewMerkleTree(data)
+
+	leaf := func(d []byte) []byte {
+		hash := sha256.Sum256(d)
+		return hash[:]
+	}
+	left := hashPair(leaf(data[0]), leaf(data[1]))
+	right := hashPair(leaf(data[2]), leaf(data[2]))
+	assert.Equal(t, hashPair(left, right), tree.Root())
+
+	for i := range data {
+		proof, err := tree.Proof(i)
+		assert.Nil(t, err)
+		assert.True(t, proof.Verify(tree.Root()))
+	}
+
+	proof, _ := tree.Proof(1)
+	proof.Leaf = leaf([]byte("tx3"))
+	assert.False(t, proof.Verify(tree.Root()))
+
+	_, err := tree.Proof(len(data))
+	assert.NotNil(t, err)
+
+	single := NewMerkleTree(data[:1])
+	assert.Equal(t, leaf(data[0]), single.Root())
+}
\ No newline at end of file
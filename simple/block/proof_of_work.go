@@ -1,83 +1,190 @@
 package block
 
 import (
-	"bytes"
+	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
+	"log"
 	"math"
 	"math/big"
-
-	"github.com/pylrichard/building_block_chain_in_go/simple/utils"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
 	maxNonce = math.MaxInt64
+	errNonceExhausted = errors.New("nonce space is exhausted")
 )
 
-const targetBits = 16
+//initialTargetBits 创世块的难度
+const initialTargetBits = 16
+const minTargetBits = 8
+const maxTargetBits = 64
+
+//MiningWorkers 挖矿使用的goroutine数量，小于等于0时使用runtime.NumCPU()
+var MiningWorkers = 0
 
 type ProofOfWork struct {
-	block *Block
-	target *big.Int
+	block		*Block
+	target		*big.Int
+	hashRate	float64
 }
 
 func NewProofOfWork(b *Block) *ProofOfWork {
 	t := big.NewInt(1)
-	t.Lsh(t, uint(256 - targetBits))
+	t.Lsh(t, uint(256 - b.Bits))
 
-	pow := &ProofOfWork{b, t}
+	pow := &ProofOfWork{block: b, target: t}
 
 	return pow
 }
 
+//prepareData 返回参与PoW计算的数据，即nonce替换后的区块头的规范编码，见BlockHeader.Serialize
 func (pow *ProofOfWork) prepareData(nonce int) []byte {
-	data := bytes.Join(
-		[][]byte{
-			pow.block.PrevBlockHash,
-			pow.block.HashTransaction(),
-			utils.IntToHex(pow.block.Timestamp),
-			utils.IntToHex(int64(targetBits)),
-			utils.IntToHex(int64(nonce)),
-		},
-		[]byte{},
-	)
-
-	return data
+	h := pow.block.Header()
+	h.Nonce = nonce
+
+	return h.Serialize()
 }
 
 func (pow *ProofOfWork) Run() (int, []byte) {
-	var hashInt big.Int
-	var hash [32]byte
-	nonce := 03
+	nonce, hash, err := pow.RunContext(context.Background())
+	if err != nil {
+		log.Panic(err)
+	}
 
-	fmt.Printf("Mining a new block")
-	for nonce < maxNonce {
-		data := pow.prepareData(nonce)
+	return nonce, hash
+}
+
+//RunContext 把nonce空间分给MiningWorkers个goroutine并行挖矿，ctx取消时停止并返回ctx.Err()
+//nonce空间耗尽时更新区块的Timestamp后重新开始
+func (pow *ProofOfWork) RunContext(ctx context.Context) (int, []byte, error) {
+	workers := MiningWorkers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
 
-		hash = sha256.Sum256(data)
-		if math.Remainder(float64(nonce), 100000) == 0 {
-			fmt.Printf("\r%x", hash)
+	fmt.Printf("Mining a new block with %d workers\n", workers)
+	for {
+		nonce, hash, err := pow.search(ctx, workers)
+		if err != errNonceExhausted {
+			fmt.Print("\n\n")
+
+			return nonce, hash, err
 		}
-		hashInt.SetBytes(hash[:])
 
-		if hashInt.Cmp(pow.target) == -1 {
-			break
-		} else {
-			nonce++
+		timestamp := time.Now().Unix()
+		if timestamp <= pow.block.Timestamp {
+			timestamp = pow.block.Timestamp + 1
 		}
+		pow.block.Timestamp = timestamp
 	}
-	fmt.Print("\n\n")
+}
 
-	return nonce, hash[:]
+//HashRate 返回最近一次挖矿每秒计算的哈希次数
+func (pow *ProofOfWork) HashRate() float64 {
+	return pow.hashRate
+}
+
+//search 第i个worker依次尝试i, i + workers, i + 2 * workers ...
+func (pow *ProofOfWork) search(parent context.Context, workers int) (int, []byte, error) {
+	type result struct {
+		nonce	int
+		hash	[]byte
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	var hashes int64
+	var wg sync.WaitGroup
+	found := make(chan result, workers)
+	start := time.Now()
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func(first int) {
+			defer wg.Done()
+			var hashInt big.Int
+
+			for nonce := first; nonce <= maxNonce - workers; nonce += workers {
+				select {
+				case <-ctx.Done():
+					return
+				default:
+				}
+
+				hash := sha256.Sum256(pow.prepareData(nonce))
+				atomic.AddInt64(&hashes, 1)
+				hashInt.SetBytes(hash[:])
+
+				if hashInt.Cmp(pow.target) == -1 {
+					found <- result{nonce, hash[:]}
+					cancel()
+
+					return
+				}
+			}
+		}(i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			rate := float64(atomic.LoadInt64(&hashes)) / time.Since(start).Seconds()
+			fmt.Printf("\r%.2f kH/s", rate / 1000)
+		case <-done:
+			pow.hashRate = float64(hashes) / time.Since(start).Seconds()
+
+			select {
+			case r := <-found:
+				fmt.Printf("\r%x", r.hash)
+
+				return r.nonce, r.hash, nil
+			default:
+			}
+
+			if parent.Err() != nil {
+				return 0, nil, parent.Err()
+			}
+
+			return 0, nil, errNonceExhausted
+		}
+	}
 }
 
 func (pow *ProofOfWork) Validate() bool {
 	var hashInt big.Int
 
+	if pow.block.Bits < minTargetBits || pow.block.Bits > maxTargetBits {
+		return false
+	}
+
 	data := pow.prepareData(pow.block.Nonce)
 	hash := sha256.Sum256(data)
 	hashInt.SetBytes(hash[:])
 	isValid := hashInt.Cmp(pow.target) == -1
 
 	return isValid
-}
\ No newline at end of file
+}
+
+//Work 返回找到满足target的哈希需要的期望计算次数，即2^256 / (target + 1)
+func (pow *ProofOfWork) Work() *big.Int {
+	max := new(big.Int).Lsh(big.NewInt(1), 256)
+	denominator := new(big.Int).Add(pow.target, big.NewInt(1))
+
+	return max.Div(max, denominator)
+}
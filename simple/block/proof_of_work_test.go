@@ -0,0 +1,25 @@
+package block
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProofOfWorkRunContext(t *testing.T) {
+	b := &Block{Timestamp: 1, Bits: minTargetBits}
+	pow := NewProofOfWork(b)
+
+	nonce, hash, err := pow.RunContext(context.Background())
+	assert.Nil(t, err)
+
+	b.Nonce = nonce
+	b.Hash = hash
+	assert.True(t, NewProofOfWork(b).Validate())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = NewProofOfWork(&Block{Timestamp: 1, Bits: maxTargetBits}).RunContext(ctx)
+	assert.Equal(t, context.Canceled, err)
+}
\ No newline at end of file
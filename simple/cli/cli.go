@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/block"
+	"github.com/pylrichard/building_block_chain_in_go/simple/config"
 )
 
 const argsNum = 2
@@ -13,14 +16,19 @@ type CLI struct {}
 
 func (cli *CLI) printUsage() {
 	fmt.Println("Usage:")
-	fmt.Println("  create_block_chain -address ADDRESS - Create a block_chain and send genesis block reward to ADDRESS")
+	fmt.Println("  create_block_chain -addr ADDRESS - Create a block_chain and send genesis block reward to ADDRESS")
 	fmt.Println("  create_wallet - Generates a new key-pair and saves it into the wallet file")
-	fmt.Println("  get_balance -address ADDRESS - Get balance of ADDRESS")
+	fmt.Println("  get_balance -addr ADDRESS -rpc - Get balance of ADDRESS, from the running node when -rpc is set")
+	fmt.Println("  index rebuild - Rebuilds the transaction and address indexes, enabling them if needed")
 	fmt.Println("  list_addr - Lists all addresses from the wallet file")
-	fmt.Println("  print_chain - Print all the blocks of the block_chain")
+	fmt.Println("  print_chain -from HEIGHT -to HEIGHT - Print the blocks of the block_chain, from tip to genesis or from HEIGHT to HEIGHT when set")
 	fmt.Println("  reindex_utxo - Rebuilds the UTXO set")
-	fmt.Println("  send -from FROM -to TO -amount AMOUNT -mine - Send AMOUNT of coins from FROM to TO. Mine on the same node, when -mine is set.")
-	fmt.Println("  start_node -miner ADDRESS - Start a node with ID specified in NODE_ID env. var. -miner enables mining")
+	fmt.Println("  rpc METHOD PARAMS... - Call a JSON-RPC method of the running node, PARAMS that are not JSON are sent as strings")
+	fmt.Println("  send -from FROM -to TO -amount AMOUNT -fee FEE -node ADDR -mine -rpc - Send AMOUNT of coins from FROM to TO and pay FEE to the miner. Mine on the same node, when -mine is set, send through the JSON-RPC of the running node, when -rpc is set, otherwise send to ADDR.")
+	fmt.Println("  start_node -listen HOST -port PORT -external ADDR -seeds ADDR,... -outbound N -miner ADDRESS -workers N -min_txs N -explorer ADDR - Start a node. -miner enables mining, -explorer serves the block explorer on ADDR")
+	fmt.Println()
+	fmt.Println("Every command accepts -config FILE, -datadir DIR, -node_id ID, -rpc_addr ADDR, -rpc_user USER and -rpc_password PASSWORD.")
+	fmt.Println("Settings are taken from defaults, then NODE_ID env. var., then FILE, then flags.")
 }
 
 func (cli *CLI) validateArgs() {
@@ -34,28 +42,36 @@ func (cli *CLI) validateArgs() {
 func (cli *CLI) Run() {
 	cli.validateArgs()
 
-	nodeId := os.Getenv("NODE_ID")
-	if nodeId == "" {
-		fmt.Printf("NODE_ID is not set!")
-		os.Exit(1)
-	}
-
 	getBalanceCmd := flag.NewFlagSet("get_balance", flag.ExitOnError)
 	createBlockChainCmd := flag.NewFlagSet("create_block_chain", flag.ExitOnError)
 	createWalletCmd := flag.NewFlagSet("create_wallet", flag.ExitOnError)
+	indexCmd := flag.NewFlagSet("index", flag.ExitOnError)
 	listAddrCmd := flag.NewFlagSet("list_addr", flag.ExitOnError)
 	printChainCmd := flag.NewFlagSet("print_chain", flag.ExitOnError)
 	reindexUTXOCmd := flag.NewFlagSet("reindex_utxo", flag.ExitOnError)
+	rpcCmd := flag.NewFlagSet("rpc", flag.ExitOnError)
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
 	startNodeCmd := flag.NewFlagSet("start_node", flag.ExitOnError)
 
+	//每个命令都接受配置参数，start_node还接受网络和挖矿参数
+	configFlags := map[string]*config.Flags{startNodeCmd.Name(): config.AddFlags(startNodeCmd, true)}
+	for _, fs := range []*flag.FlagSet{getBalanceCmd, createBlockChainCmd, createWalletCmd, indexCmd,
+		listAddrCmd, printChainCmd, reindexUTXOCmd, rpcCmd, sendCmd} {
+		configFlags[fs.Name()] = config.AddFlags(fs, false)
+	}
+
 	getBalanceAddr := getBalanceCmd.String("addr", "", "The address to get balance for")
+	getBalanceRPC := getBalanceCmd.Bool("rpc", false, "Ask the running node through JSON-RPC")
 	createBlockChainAddr := createBlockChainCmd.String("addr", "", "The address to send genesis block reward to")
 	sendFrom := sendCmd.String("from", "", "Source wallet address")
 	sendTo := sendCmd.String("to", "", "Destination wallet address")
 	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
+	sendFee := sendCmd.Int("fee", 0, "Fee paid to the miner")
 	sendMine := sendCmd.Bool("mine", false, "Mine immediately on the same node")
-	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and send reward to ADDRESS")
+	sendNode := sendCmd.String("node", "", "Node to send the transaction to, defaults to the first seed")
+	sendRPC := sendCmd.Bool("rpc", false, "Create and send the transaction through the JSON-RPC of the running node")
+	printChainFrom := printChainCmd.Int("from", -1, "Print main chain blocks starting at this height")
+	printChainTo := printChainCmd.Int("to", -1, "Print main chain blocks up to this height")
 
 	switch os.Args[1] {
 	case "get_balance":
@@ -73,6 +89,11 @@ func (cli *CLI) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "index":
+		err := indexCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "list_addr":
 		err := listAddrCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -88,6 +109,11 @@ func (cli *CLI) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "rpc":
+		err := rpcCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "send":
 		err := sendCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -103,11 +129,19 @@ func (cli *CLI) Run() {
 		os.Exit(1)
 	}
 
+	cfg, err := configFlags[os.Args[1]].Load()
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	block.MiningWorkers = cfg.Mining.Workers
+
 	if getBalanceCmd.Parsed() {
 		if *getBalanceAddr == "" {
 			getBalanceCmd.Usage()
 			os.Exit(1)
 		}
+		cli.getBalance(*getBalanceAddr, cfg, *getBalanceRPC)
 	}
 
 	if createBlockChainCmd.Parsed() {
@@ -115,29 +149,50 @@ func (cli *CLI) Run() {
 			createBlockChainCmd.Usage()
 			os.Exit(1)
 		}
+		cli.createBlockChain(*createBlockChainAddr, cfg)
 	}
 
 	if createWalletCmd.Parsed() {
+		cli.createWallet(cfg)
+	}
+
+	if indexCmd.Parsed() {
+		if indexCmd.NArg() != 1 || indexCmd.Arg(0) != "rebuild" {
+			cli.printUsage()
+			os.Exit(1)
+		}
+		cli.rebuildIndex(cfg)
 	}
 
 	if listAddrCmd.Parsed() {
+		cli.listAddrs(cfg)
 	}
 
 	if printChainCmd.Parsed() {
+		cli.printChain(cfg, *printChainFrom, *printChainTo)
 	}
 
 	if reindexUTXOCmd.Parsed() {
+		cli.reindexUTXO(cfg)
+	}
+
+	if rpcCmd.Parsed() {
+		if rpcCmd.NArg() < 1 {
+			cli.printUsage()
+			os.Exit(1)
+		}
+		cli.rpc(cfg, rpcCmd.Arg(0), rpcCmd.Args()[1:])
 	}
 
 	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
+		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 || *sendFee < 0 || (*sendMine && *sendRPC) {
 			sendCmd.Usage()
 			os.Exit(1)
 		}
-		cli.send(*sendFrom, *sendTo, *sendAmount, nodeId, *sendMine)
+		cli.send(*sendFrom, *sendTo, *sendAmount, *sendFee, cfg, *sendNode, *sendMine, *sendRPC)
 	}
 
 	if startNodeCmd.Parsed() {
-		cli.startNode(nodeId, *startNodeMiner)
+		cli.startNode(cfg)
 	}
 }
\ No newline at end of file
@@ -4,13 +4,18 @@ import (
 	"log"
 
 	"github.com/pylrichard/building_block_chain_in_go/simple/block"
+	"github.com/pylrichard/building_block_chain_in_go/simple/config"
+	"github.com/pylrichard/building_block_chain_in_go/simple/utxo"
 	"github.com/pylrichard/building_block_chain_in_go/simple/wallet"
 )
 
-func (cli *CLI) createBlockChain(addr, nodeId string) {
+func (cli *CLI) createBlockChain(addr string, cfg *config.Config) {
 	if !wallet.ValidateAddr(addr) {
 		log.Panic("Error: addr is not valid")
 	}
-	bc := block.NewChainWithGenesis(addr, nodeId)
+	bc := block.NewChainWithGenesis(addr, cfg.DataDir, cfg.NodeId)
 	defer bc.Db.Close()
+
+	set := utxo.Set{Chain: bc}
+	set.Reindex()
 }
\ No newline at end of file
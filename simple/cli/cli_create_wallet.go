@@ -0,0 +1,22 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/config"
+	"github.com/pylrichard/building_block_chain_in_go/simple/wallet"
+)
+
+func (cli *CLI) createWallet(cfg *config.Config) {
+	//钱包文件不存在时创建新文件
+	wallets, err := wallet.NewWallets(cfg.DataDir, cfg.NodeId)
+	if err != nil && !os.IsNotExist(err) {
+		log.Panic(err)
+	}
+	addr := wallets.CreateWallet()
+	wallets.SaveToFile(cfg.DataDir, cfg.NodeId)
+
+	fmt.Printf("Your new address: %s\n", addr)
+}
\ No newline at end of file
@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/block"
+	"github.com/pylrichard/building_block_chain_in_go/simple/config"
+	"github.com/pylrichard/building_block_chain_in_go/simple/codec"
+	"github.com/pylrichard/building_block_chain_in_go/simple/server"
+	"github.com/pylrichard/building_block_chain_in_go/simple/utxo"
+	"github.com/pylrichard/building_block_chain_in_go/simple/wallet"
+)
+
+//getBalance useRPC为true时向正在运行的节点查询余额
+func (cli *CLI) getBalance(addr string, cfg *config.Config, useRPC bool) {
+	if !wallet.ValidateAddr(addr) {
+		log.Panic("Error: addr is not valid")
+	}
+
+	if useRPC {
+		client, err := server.NewRPCClient(cfg)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+
+		var balance int
+		err = client.Call("getbalance", &balance, addr)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+
+		fmt.Printf("Balance of '%s': %d\n", addr, balance)
+		return
+	}
+
+	bc := block.NewChain(cfg.DataDir, cfg.NodeId)
+	set := utxo.Set{Chain: bc}
+	defer bc.Db.Close()
+
+	balance := 0
+	pubKeyHash := codec.Base58Decode([]byte(addr))
+	pubKeyHash = pubKeyHash[1 : len(pubKeyHash) - 4]
+	UTXOs := set.FindUTXO(pubKeyHash)
+
+	for _, out := range UTXOs {
+		balance += out.Value
+	}
+
+	fmt.Printf("Balance of '%s': %d\n", addr, balance)
+}
\ No newline at end of file
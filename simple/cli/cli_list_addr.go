@@ -0,0 +1,26 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/config"
+	"github.com/pylrichard/building_block_chain_in_go/simple/wallet"
+)
+
+func (cli *CLI) listAddrs(cfg *config.Config) {
+	wallets, err := wallet.NewWallets(cfg.DataDir, cfg.NodeId)
+	if os.IsNotExist(err) {
+		fmt.Println("No wallet file, create a wallet with create_wallet first")
+
+		return
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+
+	for _, addr := range wallets.GetAddrs() {
+		fmt.Println(addr)
+	}
+}
\ No newline at end of file
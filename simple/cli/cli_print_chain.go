@@ -5,28 +5,48 @@ import (
 	"strconv"
 
 	"github.com/pylrichard/building_block_chain_in_go/simple/block"
+	"github.com/pylrichard/building_block_chain_in_go/simple/config"
 )
 
-func (cli *CLI) printChain(nodeId string) {
-	bc := block.NewChain(nodeId)
+//printChain from和to都小于0时从tip往回打印整条链，否则沿主链从from打印到to，to小于0时打印到tip
+func (cli *CLI) printChain(cfg *config.Config, from, to int) {
+	bc := block.NewChain(cfg.DataDir, cfg.NodeId)
 	defer bc.Db.Close()
 
-	bci := bc.Iterator()
-
-	for {
-		b := bci.Next()
-		fmt.Printf("---- Block %x", b.Hash)
-		fmt.Printf("Height: %d\n", b.Height)
-		fmt.Printf("Prev Block: %x\n", b.PrevBlockHash)
-		pow := block.NewProofOfWork(b)
-		fmt.Printf("PoW: %s\n", strconv.FormatBool(pow.Validate()))
-		for _, tx := range b.Transactions {
-			fmt.Println(tx)
-		}
-		fmt.Println()
+	if from < 0 && to < 0 {
+		bci := bc.Iterator()
+
+		for {
+			b := bci.Next()
+			printBlock(b)
 
-		if len(b.PrevBlockHash) == 0 {
-			break
+			if len(b.PrevBlockHash) == 0 {
+				break
+			}
 		}
+
+		return
+	}
+
+	if from < 0 {
+		from = 0
+	}
+
+	fi := bc.ForwardIterator(from, to)
+	for b := fi.Next(); b != nil; b = fi.Next() {
+		printBlock(b)
+	}
+}
+
+func printBlock(b *block.Block) {
+	fmt.Printf("---- Block %x\n", b.Hash)
+	fmt.Printf("Height: %d\n", b.Height)
+	fmt.Printf("Prev Block: %x\n", b.PrevBlockHash)
+	fmt.Printf("Bits: %d\n", b.Bits)
+	pow := block.NewProofOfWork(b)
+	fmt.Printf("PoW: %s\n", strconv.FormatBool(pow.Validate()))
+	for _, tx := range b.Transactions {
+		fmt.Println(tx)
 	}
+	fmt.Println()
 }
\ No newline at end of file
@@ -0,0 +1,21 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/block"
+	"github.com/pylrichard/building_block_chain_in_go/simple/config"
+)
+
+func (cli *CLI) rebuildIndex(cfg *config.Config) {
+	bc := block.NewChain(cfg.DataDir, cfg.NodeId)
+	defer bc.Db.Close()
+
+	count, err := bc.RebuildIndexes()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Done! There are %d transactions in the index.\n", count)
+}
\ No newline at end of file
@@ -0,0 +1,20 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/block"
+	"github.com/pylrichard/building_block_chain_in_go/simple/config"
+	"github.com/pylrichard/building_block_chain_in_go/simple/utxo"
+)
+
+func (cli *CLI) reindexUTXO(cfg *config.Config) {
+	bc := block.NewChain(cfg.DataDir, cfg.NodeId)
+	set := utxo.Set{Chain: bc}
+	defer bc.Db.Close()
+
+	set.Reindex()
+
+	count := set.CountTransactions()
+	fmt.Printf("Done! There are %d transactions in the UTXO set.\n", count)
+}
\ No newline at end of file
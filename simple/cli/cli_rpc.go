@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/config"
+	"github.com/pylrichard/building_block_chain_in_go/simple/server"
+)
+
+//rpc 调用正在运行的节点的JSON-RPC方法并打印结果，参数是合法的JSON时按JSON发送，否则按字符串发送
+func (cli *CLI) rpc(cfg *config.Config, method string, args []string) {
+	client, err := server.NewRPCClient(cfg)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	params := make([]interface{}, 0, len(args))
+	for _, arg := range args {
+		if json.Valid([]byte(arg)) {
+			params = append(params, json.RawMessage(arg))
+		} else {
+			params = append(params, arg)
+		}
+	}
+
+	var result json.RawMessage
+	err = client.Call(method, &result, params...)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	var out bytes.Buffer
+	err = json.Indent(&out, result, "", "  ")
+	if err != nil {
+		fmt.Println(string(result))
+		return
+	}
+	fmt.Println(out.String())
+}
\ No newline at end of file
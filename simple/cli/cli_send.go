@@ -1,5 +1,144 @@
 package cli
 
-func (cli *CLI) send(from, to string, amount int,
-					nodeId string, mineNow bool) {
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/block"
+	"github.com/pylrichard/building_block_chain_in_go/simple/config"
+	"github.com/pylrichard/building_block_chain_in_go/simple/server"
+	"github.com/pylrichard/building_block_chain_in_go/simple/transaction"
+	"github.com/pylrichard/building_block_chain_in_go/simple/utxo"
+	"github.com/pylrichard/building_block_chain_in_go/simple/wallet"
+)
+
+//send 不在本节点挖矿时把交易发送给nodeAddr，nodeAddr为空时发送给第一个种子节点
+//useRPC为true时通过正在运行的节点的JSON-RPC创建并发送交易，不打开节点的数据库
+func (cli *CLI) send(from, to string, amount, fee int,
+					cfg *config.Config, nodeAddr string, mineNow, useRPC bool) {
+	if !wallet.ValidateAddr(from) {
+		log.Panic("Error: Sender address is not valid")
+	}
+	if !wallet.ValidateAddr(to) {
+		log.Panic("Error: Recipient address is not valid")
+	}
+
+	wallets, err := wallet.NewWallets(cfg.DataDir, cfg.NodeId)
+	if err != nil {
+		log.Panic(err)
+	}
+	w, err := wallets.GetWallet(from)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if useRPC {
+		err = sendRPC(&w, to, amount, fee, cfg)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+
+		fmt.Println("Success!")
+		return
+	}
+
+	bc := block.NewChain(cfg.DataDir, cfg.NodeId)
+	set := utxo.Set{Chain: bc}
+	defer bc.Db.Close()
+
+	tx, err := utxo.NewUTXOTransaction(&w, to, amount, fee, &set)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	if mineNow {
+		//在本节点挖矿时手续费由from自己获得
+		cbTx := transaction.NewCoinBaseTx(from, "", bc.GetBestHeight() + 1, fee)
+		txs := []*transaction.Transaction{cbTx, tx}
+
+		_, err := bc.MineBlock(txs)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	} else {
+		if nodeAddr == "" && len(cfg.Seeds) > 0 {
+			nodeAddr = cfg.Seeds[0]
+		}
+		if nodeAddr == "" {
+			nodeAddr = cfg.AdvertisedAddr()
+		}
+		err := server.SendTx(nodeAddr, tx)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	}
+
+	fmt.Println("Success!")
+}
+
+//sendRPC 通过listunspent选择w可以花费的输出，取得引用的交易后在本地签名，再用sendrawtransaction发送给节点
+func sendRPC(w *wallet.Wallet, to string, amount, fee int, cfg *config.Config) error {
+	client, err := server.NewRPCClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	var unspent []server.UnspentResult
+	err = client.Call("listunspent", &unspent, string(w.GetAddr()))
+	if err != nil {
+		return err
+	}
+
+	acc := 0
+	validOutputs := make(map[string][]int)
+	for _, out := range unspent {
+		if acc >= amount + fee {
+			break
+		}
+		if !out.Spendable {
+			continue
+		}
+
+		acc += out.Value
+		validOutputs[out.TxId] = append(validOutputs[out.TxId], out.Out)
+	}
+
+	tx, err := utxo.NewTransaction(w, to, amount, fee, acc, validOutputs)
+	if err != nil {
+		return err
+	}
+
+	prevTxs := make(map[string]transaction.Transaction)
+	for txId := range validOutputs {
+		var rawTx string
+		err = client.Call("gettransaction", &rawTx, txId, false)
+		if err != nil {
+			return err
+		}
+
+		data, err := hex.DecodeString(rawTx)
+		if err != nil {
+			return err
+		}
+		prevTx, err := transaction.DecodeTransaction(data)
+		if err != nil {
+			return err
+		}
+		prevTxs[txId] = prevTx
+	}
+	tx.Sign(w.PrivateKey, prevTxs)
+
+	var txId string
+	err = client.Call("sendrawtransaction", &txId, hex.EncodeToString(tx.Serialize()))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Transaction %s is sent\n", txId)
+
+	return nil
 }
\ No newline at end of file
@@ -1,15 +1,21 @@
 package cli
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
 
+	"github.com/pylrichard/building_block_chain_in_go/simple/block"
+	"github.com/pylrichard/building_block_chain_in_go/simple/config"
 	"github.com/pylrichard/building_block_chain_in_go/simple/server"
 	"github.com/pylrichard/building_block_chain_in_go/simple/wallet"
 )
 
-func (cli *CLI) startNode(nodeId, minerAddr string) {
-	fmt.Printf("Starting node %s\n", nodeId)
+func (cli *CLI) startNode(cfg *config.Config) {
+	fmt.Printf("Starting node %s on %s, advertised as %s\n", cfg.NodeId, cfg.ListenAddr(), cfg.AdvertisedAddr())
+	minerAddr := cfg.Mining.Addr
 	if len(minerAddr) > 0 {
 		if wallet.ValidateAddr(minerAddr) {
 			fmt.Println("Mining is on, Address to receive rewards:", minerAddr)
@@ -18,5 +24,23 @@ func (cli *CLI) startNode(nodeId, minerAddr string) {
 		}
 	}
 
-	server.StartServer(nodeId, minerAddr)
+	bc := block.NewChain(cfg.DataDir, cfg.NodeId)
+	defer bc.Db.Close()
+
+	node := server.NewNode(cfg, bc)
+
+	//Ctrl+C时停止节点，关闭数据库
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		node.Shutdown()
+	}()
+
+	err := node.Run(ctx)
+	if err != nil {
+		log.Panic(err)
+	}
+	node.Shutdown()
 }
\ No newline at end of file
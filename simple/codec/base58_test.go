@@ -21,4 +21,13 @@ func TestBase58(t *testing.T) {
 
 	decoded := Base58Decode([]byte("16UwLL9Risc3QfPqBUvKofHmBQ7wMtjvM"))
 	assert.Equal(t, strings.ToLower("00010966776006953D5567439E5E39F86A0D273BEED61967F6"), hex.EncodeToString(decoded))
+
+	//版本号和pubKeyHash都以0开头时保留两个前导0
+	hash, err = hex.DecodeString("00002a54c5a9fa045c1321bd601880655525ebb4fe")
+	if err != nil {
+		log.Fatal(err)
+	}
+	encoded = Base58Encode(hash)
+	assert.Equal(t, "11", string(encoded[:2]))
+	assert.Equal(t, hash, Base58Decode(encoded))
 }
\ No newline at end of file
@@ -0,0 +1,215 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+//规范的二进制格式：定长整数使用小端序，变长整数使用CompactSize编码
+//CompactSize：小于0xfd时为1字节，否则为0xfd/0xfe/0xff后跟2/4/8字节的小端整数，必须使用最短编码
+//字节串为CompactSize长度加内容
+
+var (
+	ErrUnexpectedEOF		= errors.New("data is shorter than expected")
+	ErrNonCanonicalVarInt	= errors.New("varint is not minimally encoded")
+	ErrTooLong				= errors.New("byte string is too long")
+	ErrTrailingBytes		= errors.New("data has trailing bytes")
+)
+
+//maxVarBytesLen 单个字节串的最大长度
+const maxVarBytesLen = 32 << 20
+
+type Writer struct {
+	buf bytes.Buffer
+}
+
+func NewWriter() *Writer {
+	return &Writer{}
+}
+
+func (w *Writer) Bytes() []byte {
+	return w.buf.Bytes()
+}
+
+func (w *Writer) WriteUint8(v uint8) {
+	w.buf.WriteByte(v)
+}
+
+func (w *Writer) WriteUint32(v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	w.buf.Write(b[:])
+}
+
+func (w *Writer) WriteUint64(v uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	w.buf.Write(b[:])
+}
+
+func (w *Writer) WriteInt64(v int64) {
+	w.WriteUint64(uint64(v))
+}
+
+func (w *Writer) WriteVarInt(v uint64) {
+	switch {
+	case v < 0xfd:
+		w.WriteUint8(uint8(v))
+	case v <= math.MaxUint16:
+		var b [2]byte
+		binary.LittleEndian.PutUint16(b[:], uint16(v))
+		w.WriteUint8(0xfd)
+		w.buf.Write(b[:])
+	case v <= math.MaxUint32:
+		w.WriteUint8(0xfe)
+		w.WriteUint32(uint32(v))
+	default:
+		w.WriteUint8(0xff)
+		w.WriteUint64(v)
+	}
+}
+
+//WriteBytes 写入data而不写入长度，用于写入已经编码的数据
+func (w *Writer) WriteBytes(data []byte) {
+	w.buf.Write(data)
+}
+
+func (w *Writer) WriteVarBytes(data []byte) {
+	w.WriteVarInt(uint64(len(data)))
+	w.buf.Write(data)
+}
+
+//Reader 按规范的二进制格式读取数据，第一次出错后的读取都返回零值，最后通过Err或Finish检查
+type Reader struct {
+	data	[]byte
+	off		int
+	err		error
+}
+
+func NewReader(data []byte) *Reader {
+	return &Reader{data: data}
+}
+
+func (r *Reader) Err() error {
+	return r.err
+}
+
+//Finish 检查数据已经全部读取
+func (r *Reader) Finish() error {
+	if r.err == nil && r.off != len(r.data) {
+		r.err = ErrTrailingBytes
+	}
+
+	return r.err
+}
+
+func (r *Reader) read(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.data) - r.off < n {
+		r.err = ErrUnexpectedEOF
+
+		return nil
+	}
+
+	b := r.data[r.off : r.off + n]
+	r.off += n
+
+	return b
+}
+
+func (r *Reader) ReadUint8() uint8 {
+	b := r.read(1)
+	if b == nil {
+		return 0
+	}
+
+	return b[0]
+}
+
+func (r *Reader) ReadUint32() uint32 {
+	b := r.read(4)
+	if b == nil {
+		return 0
+	}
+
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (r *Reader) ReadUint64() uint64 {
+	b := r.read(8)
+	if b == nil {
+		return 0
+	}
+
+	return binary.LittleEndian.Uint64(b)
+}
+
+func (r *Reader) ReadInt64() int64 {
+	return int64(r.ReadUint64())
+}
+
+func (r *Reader) ReadVarInt() uint64 {
+	var v, min uint64
+
+	switch prefix := r.ReadUint8(); prefix {
+	case 0xfd:
+		b := r.read(2)
+		if b == nil {
+			return 0
+		}
+		v, min = uint64(binary.LittleEndian.Uint16(b)), 0xfd
+	case 0xfe:
+		v, min = uint64(r.ReadUint32()), math.MaxUint16 + 1
+	case 0xff:
+		v, min = r.ReadUint64(), math.MaxUint32 + 1
+	default:
+		return uint64(prefix)
+	}
+
+	if r.err == nil && v < min {
+		r.err = ErrNonCanonicalVarInt
+
+		return 0
+	}
+
+	return v
+}
+
+//ReadVarBytes 读取字节串，返回的切片是新分配的
+func (r *Reader) ReadVarBytes() []byte {
+	n := r.ReadVarInt()
+	if r.err != nil {
+		return nil
+	}
+	if n > maxVarBytesLen {
+		r.err = ErrTooLong
+
+		return nil
+	}
+
+	b := r.read(int(n))
+	if b == nil {
+		return nil
+	}
+
+	return append([]byte{}, b...)
+}
+
+//ReadCount 读取元素个数，每个元素至少占minSize字节，个数超过剩余数据能容纳的数量时出错
+func (r *Reader) ReadCount(minSize int) int {
+	n := r.ReadVarInt()
+	if r.err != nil {
+		return 0
+	}
+	if minSize > 0 && n > uint64((len(r.data) - r.off) / minSize) {
+		r.err = ErrUnexpectedEOF
+
+		return 0
+	}
+
+	return int(n)
+}
\ No newline at end of file
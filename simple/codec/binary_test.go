@@ -0,0 +1,42 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBinaryRoundTrip(t *testing.T) {
+	w := NewWriter()
+	w.WriteUint8(7)
+	w.WriteUint32(0xdeadbeef)
+	w.WriteInt64(-1)
+	w.WriteVarInt(0xfc)
+	w.WriteVarInt(0xfd)
+	w.WriteVarInt(1 << 40)
+	w.WriteVarBytes([]byte("abc"))
+
+	r := NewReader(w.Bytes())
+	assert.Equal(t, uint8(7), r.ReadUint8())
+	assert.Equal(t, uint32(0xdeadbeef), r.ReadUint32())
+	assert.Equal(t, int64(-1), r.ReadInt64())
+	assert.Equal(t, uint64(0xfc), r.ReadVarInt())
+	assert.Equal(t, uint64(0xfd), r.ReadVarInt())
+	assert.Equal(t, uint64(1 << 40), r.ReadVarInt())
+	assert.Equal(t, []byte("abc"), r.ReadVarBytes())
+	assert.Nil(t, r.Finish())
+}
+
+func TestBinaryRejectsMalformed(t *testing.T) {
+	r := NewReader([]byte{0xfd, 0x10, 0x00})
+	r.ReadVarInt()
+	assert.Equal(t, ErrNonCanonicalVarInt, r.Err())
+
+	r = NewReader([]byte{0x05, 'a'})
+	assert.Nil(t, r.ReadVarBytes())
+	assert.Equal(t, ErrUnexpectedEOF, r.Err())
+
+	r = NewReader([]byte{0x01, 0x02})
+	r.ReadUint8()
+	assert.Equal(t, ErrTrailingBytes, r.Finish())
+}
\ No newline at end of file
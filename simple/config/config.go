@@ -0,0 +1,271 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//配置的优先级从低到高：默认值、NODE_ID环境变量、配置文件、命令行参数
+
+const defaultHost = "localhost"
+const defaultPort = 3000
+const defaultSeed = "localhost:3000"
+const defaultOutboundPeers = 8
+//defaultMinTxs mempool中的交易数达到该值时开始挖矿
+const defaultMinTxs = 2
+//defaultRPCPortOffset 没有配置RPC地址时，RPC端口为监听端口加上该值
+const defaultRPCPortOffset = 5000
+const rpcCookieFileTemplate = "rpc_%s.cookie"
+
+//Config 节点的配置，配置文件使用JSON格式，字段名见json tag
+type Config struct {
+	//NodeId 区分同一个目录下不同节点的数据文件，为空时使用ListenPort
+	NodeId			string			`json:"node_id"`
+	DataDir			string			`json:"data_dir"`
+	ListenHost		string			`json:"listen_host"`
+	ListenPort		int				`json:"listen_port"`
+	//ExternalAddr 发送给其他节点的地址，为空时使用ListenHost和ListenPort
+	ExternalAddr	string			`json:"external_addr"`
+	//Seeds 启动时连接的节点，没有出站连接时重新连接
+	Seeds			[]string		`json:"seeds"`
+	//OutboundPeers 保持的出站连接数量
+	OutboundPeers	int				`json:"outbound_peers"`
+	Mining			MiningConfig	`json:"mining"`
+	RPC				RPCConfig		`json:"rpc"`
+	//ExplorerAddr 区块浏览器监听的地址，为空时不启动
+	ExplorerAddr	string			`json:"explorer_addr"`
+}
+
+type MiningConfig struct {
+	//Addr 接收挖矿奖励的地址，为空时不挖矿
+	Addr	string	`json:"addr"`
+	//Workers 挖矿的goroutine数量，为0时使用CPU数量
+	Workers	int		`json:"workers"`
+	MinTxs	int		`json:"min_txs"`
+}
+
+//RPCConfig JSON-RPC服务的配置，User和Password为空时使用数据目录中的cookie文件认证
+type RPCConfig struct {
+	//Addr RPC服务监听的地址，为空时使用localhost和监听端口加5000
+	Addr		string	`json:"addr"`
+	User		string	`json:"user"`
+	Password	string	`json:"password"`
+}
+
+//Default 返回默认配置，设置了NODE_ID时使用它作为节点Id和监听端口
+func Default() *Config {
+	cfg := &Config{
+		DataDir:		".",
+		ListenHost:		defaultHost,
+		ListenPort:		defaultPort,
+		Seeds:			[]string{defaultSeed},
+		OutboundPeers:	defaultOutboundPeers,
+		Mining:			MiningConfig{MinTxs: defaultMinTxs},
+	}
+
+	if nodeId := os.Getenv("NODE_ID"); nodeId != "" {
+		cfg.NodeId = nodeId
+		if port, err := strconv.Atoi(nodeId); err == nil {
+			cfg.ListenPort = port
+		}
+	}
+
+	return cfg
+}
+
+//LoadFile 用配置文件中出现的字段覆盖cfg，不认识的字段视为错误
+func (c *Config) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(c)
+	if err != nil {
+		return fmt.Errorf("config file %s: %s", path, err)
+	}
+
+	return nil
+}
+
+//ListenAddr 返回监听的地址
+func (c *Config) ListenAddr() string {
+	return net.JoinHostPort(c.ListenHost, strconv.Itoa(c.ListenPort))
+}
+
+//AdvertisedAddr 返回其他节点连接本节点使用的地址
+func (c *Config) AdvertisedAddr() string {
+	if c.ExternalAddr != "" {
+		return c.ExternalAddr
+	}
+
+	host := c.ListenHost
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = defaultHost
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(c.ListenPort))
+}
+
+//RPCAddr 返回RPC服务的地址
+func (c *Config) RPCAddr() string {
+	if c.RPC.Addr != "" {
+		return c.RPC.Addr
+	}
+
+	return net.JoinHostPort(defaultHost, strconv.Itoa(c.ListenPort + defaultRPCPortOffset))
+}
+
+//RPCCookieFile 返回RPC cookie文件的路径，节点启动时写入，客户端读取
+func (c *Config) RPCCookieFile() string {
+	return filepath.Join(c.DataDir, fmt.Sprintf(rpcCookieFileTemplate, c.NodeId))
+}
+
+//Validate 检查配置并补全NodeId
+func (c *Config) Validate() error {
+	if c.ListenPort <= 0 || c.ListenPort > 65535 {
+		return fmt.Errorf("listen port %d is out of range", c.ListenPort)
+	}
+	if c.DataDir == "" {
+		return errors.New("data dir is empty")
+	}
+	if c.OutboundPeers < 1 {
+		return errors.New("outbound peers must be at least 1")
+	}
+	if c.Mining.Workers < 0 {
+		return errors.New("mining workers can not be negative")
+	}
+	if c.Mining.MinTxs < 1 {
+		return errors.New("mining min txs must be at least 1")
+	}
+
+	if (c.RPC.User == "") != (c.RPC.Password == "") {
+		return errors.New("rpc user and password must be set together")
+	}
+
+	for _, addr := range append([]string{c.ExternalAddr, c.RPC.Addr, c.ExplorerAddr}, c.Seeds...) {
+		if addr == "" {
+			continue
+		}
+
+		_, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fmt.Errorf("invalid address %q: %s", addr, err)
+		}
+	}
+
+	if c.NodeId == "" {
+		c.NodeId = strconv.Itoa(c.ListenPort)
+	}
+
+	return nil
+}
+
+//Flags 把配置项注册为命令行参数，只有显式设置的参数覆盖配置文件
+type Flags struct {
+	fs		*flag.FlagSet
+	file	*string
+	values	Config
+	seeds	string
+}
+
+//AddFlags 为fs注册-config、-datadir和RPC参数，node为true时注册节点的网络和挖矿参数
+func AddFlags(fs *flag.FlagSet, node bool) *Flags {
+	f := &Flags{fs: fs}
+
+	f.file = fs.String("config", "", "Load the node configuration from FILE")
+	fs.StringVar(&f.values.DataDir, "datadir", "", "Directory to store the block chain and wallet files")
+	fs.StringVar(&f.values.NodeId, "node_id", "", "Id used to name the data files, defaults to the listen port")
+	fs.StringVar(&f.values.RPC.Addr, "rpc_addr", "", "Address of the JSON-RPC server, defaults to localhost and the listen port plus 5000")
+	fs.StringVar(&f.values.RPC.User, "rpc_user", "", "JSON-RPC user, the cookie file in the data dir is used when empty")
+	fs.StringVar(&f.values.RPC.Password, "rpc_password", "", "JSON-RPC password")
+
+	if node {
+		fs.StringVar(&f.values.ListenHost, "listen", "", "Host to listen on")
+		fs.IntVar(&f.values.ListenPort, "port", 0, "Port to listen on")
+		fs.StringVar(&f.values.ExternalAddr, "external", "", "Address advertised to other nodes")
+		fs.StringVar(&f.seeds, "seeds", "", "Comma separated seed node addresses")
+		fs.IntVar(&f.values.OutboundPeers, "outbound", 0, "Number of outbound connections to keep")
+		fs.StringVar(&f.values.Mining.Addr, "miner", "", "Enable mining mode and send reward to ADDRESS")
+		fs.IntVar(&f.values.Mining.Workers, "workers", 0, "Number of mining goroutines, 0 means one per CPU")
+		fs.IntVar(&f.values.Mining.MinTxs, "min_txs", 0, "Start mining when the mempool has this many transactions")
+		fs.StringVar(&f.values.ExplorerAddr, "explorer", "", "Serve the block explorer and its REST API on ADDR")
+	}
+
+	return f
+}
+
+//Load 在fs解析后调用，返回合并了默认值、配置文件和命令行参数的配置
+func (f *Flags) Load() (*Config, error) {
+	cfg := Default()
+
+	if *f.file != "" {
+		err := cfg.LoadFile(*f.file)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	f.fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "datadir":
+			cfg.DataDir = f.values.DataDir
+		case "node_id":
+			cfg.NodeId = f.values.NodeId
+		case "listen":
+			cfg.ListenHost = f.values.ListenHost
+		case "port":
+			cfg.ListenPort = f.values.ListenPort
+		case "external":
+			cfg.ExternalAddr = f.values.ExternalAddr
+		case "seeds":
+			cfg.Seeds = splitList(f.seeds)
+		case "outbound":
+			cfg.OutboundPeers = f.values.OutboundPeers
+		case "miner":
+			cfg.Mining.Addr = f.values.Mining.Addr
+		case "workers":
+			cfg.Mining.Workers = f.values.Mining.Workers
+		case "min_txs":
+			cfg.Mining.MinTxs = f.values.Mining.MinTxs
+		case "explorer":
+			cfg.ExplorerAddr = f.values.ExplorerAddr
+		case "rpc_addr":
+			cfg.RPC.Addr = f.values.RPC.Addr
+		case "rpc_user":
+			cfg.RPC.User = f.values.RPC.User
+		case "rpc_password":
+			cfg.RPC.Password = f.values.RPC.Password
+		}
+	})
+
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func splitList(s string) []string {
+	var items []string
+
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
\ No newline at end of file
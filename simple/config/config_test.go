@@ -0,0 +1,35 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlagsOverrideFile(t *testing.T) {
+	os.Unsetenv("NODE_ID")
+
+	file := filepath.Join(t.TempDir(), "node.json")
+	data := `{"listen_host": "0.0.0.0", "listen_port": 4000, "seeds": ["a:1", "b:2"], "mining": {"workers": 2}}`
+	assert.Nil(t, os.WriteFile(file, []byte(data), 0600))
+
+	fs := flag.NewFlagSet("start_node", flag.ContinueOnError)
+	f := AddFlags(fs, true)
+	assert.Nil(t, fs.Parse([]string{"-config", file, "-port", "4001", "-seeds", "c:3, d:4"}))
+
+	cfg, err := f.Load()
+	assert.Nil(t, err)
+	assert.Equal(t, "0.0.0.0:4001", cfg.ListenAddr())
+	assert.Equal(t, "localhost:4001", cfg.AdvertisedAddr())
+	assert.Equal(t, []string{"c:3", "d:4"}, cfg.Seeds)
+	assert.Equal(t, 2, cfg.Mining.Workers)
+	assert.Equal(t, defaultMinTxs, cfg.Mining.MinTxs)
+	assert.Equal(t, "4001", cfg.NodeId)
+	assert.Equal(t, "localhost:9001", cfg.RPCAddr())
+
+	assert.Nil(t, os.WriteFile(file, []byte(`{"listen_prot": 4000}`), 0600))
+	assert.NotNil(t, Default().LoadFile(file))
+}
\ No newline at end of file
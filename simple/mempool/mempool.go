@@ -0,0 +1,358 @@
+package mempool
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/block"
+	"github.com/pylrichard/building_block_chain_in_go/simple/transaction"
+	"github.com/pylrichard/building_block_chain_in_go/simple/utxo"
+)
+
+var (
+	ErrAlreadyExists	= errors.New("transaction is already in mempool")
+	ErrCoinBase			= errors.New("coinbase transaction is not allowed in mempool")
+	ErrMissingInputs	= errors.New("transaction spends missing or spent outputs")
+	ErrDoubleSpend		= errors.New("transaction conflicts with a transaction in mempool")
+	ErrNegativeFee		= errors.New("transaction outputs exceed its inputs")
+	ErrPoolFull			= errors.New("mempool is full and transaction fee rate is too low")
+)
+
+//entry 是mempool中的一笔交易，fee为输入总额减输出总额，size为序列化后的字节数
+type entry struct {
+	tx		transaction.Transaction
+	fee		int
+	size	int
+}
+
+//Pool 保存等待打包的交易，进入时根据UTXO Set验证，拒绝和已有交易花费同一输出的交易
+type Pool struct {
+	lock	sync.Mutex
+	set		utxo.Set
+	maxSize	int
+	size	int
+	txs		map[string]*entry
+	//spent 记录mempool中交易花费的输出，key为outpointKey，value为花费它的交易Id
+	spent	map[string]string
+}
+
+//NewPool 创建总大小不超过maxSize字节的mempool
+func NewPool(set utxo.Set, maxSize int) *Pool {
+	return &Pool{
+		set:		set,
+		maxSize:	maxSize,
+		txs:		make(map[string]*entry),
+		spent:		make(map[string]string),
+	}
+}
+
+//Add 验证交易并加入mempool，mempool已满时驱逐费率最低的交易
+func (p *Pool) Add(tx transaction.Transaction) error {
+	if tx.IsCoinBase() {
+		return ErrCoinBase
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	txId := hex.EncodeToString(tx.Id)
+	if _, ok := p.txs[txId]; ok {
+		return ErrAlreadyExists
+	}
+
+	inputValue := 0
+	seen := make(map[string]bool)
+	for _, in := range tx.In {
+		key := outpointKey(in.TxId, in.Out)
+		if seen[key] {
+			return ErrDoubleSpend
+		}
+		seen[key] = true
+
+		if spender, ok := p.spent[key]; ok {
+			return fmt.Errorf("%w: output %s is spent by %s", ErrDoubleSpend, key, spender)
+		}
+
+		out, ok := p.set.FindOutput(in.TxId, in.Out)
+		if !ok {
+			return ErrMissingInputs
+		}
+		inputValue += out.Value
+		if !transaction.MoneyRange(inputValue) {
+			return transaction.ErrValueOutOfRange
+		}
+	}
+
+	outputValue, err := tx.OutputValue()
+	if err != nil {
+		return err
+	}
+	if outputValue > inputValue {
+		return ErrNegativeFee
+	}
+
+	err = p.set.Chain.ValidateTransaction(&tx)
+	if err != nil {
+		return err
+	}
+
+	e := &entry{tx, inputValue - outputValue, len(tx.Serialize())}
+	if !p.makeRoom(e) {
+		return ErrPoolFull
+	}
+
+	p.txs[txId] = e
+	p.size += e.size
+	for _, in := range tx.In {
+		p.spent[outpointKey(in.TxId, in.Out)] = txId
+	}
+
+	return nil
+}
+
+//Get 根据交易Id返回mempool中的交易
+func (p *Pool) Get(txId []byte) (transaction.Transaction, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	e, ok := p.txs[hex.EncodeToString(txId)]
+	if !ok {
+		return transaction.Transaction{}, false
+	}
+
+	return e.tx, true
+}
+
+func (p *Pool) Has(txId []byte) bool {
+	_, ok := p.Get(txId)
+
+	return ok
+}
+
+//Count 返回mempool中交易的数量
+func (p *Pool) Count() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return len(p.txs)
+}
+
+//Transactions 按费率从高到低返回mempool中的交易
+func (p *Pool) Transactions() []*transaction.Transaction {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var txs []*transaction.Transaction
+	for _, e := range p.sortedEntries() {
+		tx := e.tx
+		txs = append(txs, &tx)
+	}
+
+	return txs
+}
+
+//BlockTemplate 按费率从高到低选择交易，总大小不超过maxSize字节，同时返回这些交易的手续费总额
+func (p *Pool) BlockTemplate(maxSize int) ([]*transaction.Transaction, int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var txs []*transaction.Transaction
+	size := 0
+	fees := 0
+
+	for _, e := range p.sortedEntries() {
+		if size + e.size > maxSize {
+			continue
+		}
+
+		tx := e.tx
+		txs = append(txs, &tx)
+		size += e.size
+		fees += e.fee
+	}
+
+	return txs, fees
+}
+
+//Fee 返回mempool中交易的手续费
+func (p *Pool) Fee(txId []byte) (int, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	e, ok := p.txs[hex.EncodeToString(txId)]
+	if !ok {
+		return 0, false
+	}
+
+	return e.fee, true
+}
+
+//Remove 从mempool中删除交易
+func (p *Pool) Remove(txId []byte) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.remove(hex.EncodeToString(txId))
+}
+
+//RemoveForBlock 区块连接到主链后，删除区块中的交易和与它们冲突的交易
+func (p *Pool) RemoveForBlock(b *block.Block) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, tx := range b.Transactions {
+		p.remove(hex.EncodeToString(tx.Id))
+
+		if tx.IsCoinBase() {
+			continue
+		}
+
+		for _, in := range tx.In {
+			if spender, ok := p.spent[outpointKey(in.TxId, in.Out)]; ok {
+				p.removeWithDescendants(spender)
+			}
+		}
+	}
+}
+
+//AddForDisconnect 区块从主链断开后，把其中的交易重新加入mempool，chainstate需要先回滚
+func (p *Pool) AddForDisconnect(b *block.Block) {
+	for _, tx := range b.Transactions {
+		if tx.IsCoinBase() {
+			continue
+		}
+
+		//已经包含在新主链中的交易会因为输入被花费而被拒绝
+		_ = p.Add(*tx)
+	}
+}
+
+//ApplyChainUpdate 根据主链变化更新mempool，chainstate已经在AddBlock中按同一个ChainUpdate更新
+func (p *Pool) ApplyChainUpdate(update *block.ChainUpdate) {
+	for _, b := range update.Connected {
+		p.RemoveForBlock(b)
+	}
+
+	if len(update.Disconnected) == 0 {
+		return
+	}
+
+	p.Revalidate()
+	for i := len(update.Disconnected) - 1; i >= 0; i-- {
+		p.AddForDisconnect(update.Disconnected[i])
+	}
+}
+
+//Revalidate 根据当前chainstate重新验证mempool中的交易，删除失效的交易和它们的后代
+//区块断开后，交易花费的输出可能由被断开的区块创建，引用的Coinbase输出也可能不再成熟
+func (p *Pool) Revalidate() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for txId, e := range p.txs {
+		if !p.isValid(&e.tx) {
+			p.removeWithDescendants(txId)
+		}
+	}
+}
+
+//makeRoom 驱逐费率低于e的交易直到e可以放入mempool，调用者需要持有p.lock
+func (p *Pool) makeRoom(e *entry) bool {
+	if e.size > p.maxSize {
+		return false
+	}
+
+	entries := p.sortedEntries()
+	freed := 0
+	var evicted []*entry
+
+	for i := len(entries) - 1; i >= 0 && p.size - freed + e.size > p.maxSize; i-- {
+		if !higherFeeRate(e, entries[i]) {
+			return false
+		}
+
+		evicted = append(evicted, entries[i])
+		freed += entries[i].size
+	}
+
+	for _, old := range evicted {
+		p.remove(hex.EncodeToString(old.tx.Id))
+	}
+
+	return true
+}
+
+//isValid 返回tx的输入是否都在chainstate中，并且tx在当前主链上有效，调用者需要持有p.lock
+func (p *Pool) isValid(tx *transaction.Transaction) bool {
+	for _, in := range tx.In {
+		if _, ok := p.set.FindOutput(in.TxId, in.Out); !ok {
+			return false
+		}
+	}
+
+	return p.set.Chain.ValidateTransaction(tx) == nil
+}
+
+//removeWithDescendants 删除交易以及花费它的输出的交易，调用者需要持有p.lock
+func (p *Pool) removeWithDescendants(txId string) {
+	e, ok := p.txs[txId]
+	if !ok {
+		return
+	}
+
+	p.remove(txId)
+	for i := range e.tx.Out {
+		if spender, ok := p.spent[outpointKey(e.tx.Id, i)]; ok {
+			p.removeWithDescendants(spender)
+		}
+	}
+}
+
+//remove 调用者需要持有p.lock
+func (p *Pool) remove(txId string) {
+	e, ok := p.txs[txId]
+	if !ok {
+		return
+	}
+
+	for _, in := range e.tx.In {
+		delete(p.spent, outpointKey(in.TxId, in.Out))
+	}
+
+	delete(p.txs, txId)
+	p.size -= e.size
+}
+
+//sortedEntries 调用者需要持有p.lock
+func (p *Pool) sortedEntries() []*entry {
+	var entries []*entry
+
+	for _, e := range p.txs {
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if higherFeeRate(entries[i], entries[j]) {
+			return true
+		}
+		if higherFeeRate(entries[j], entries[i]) {
+			return false
+		}
+
+		return hex.EncodeToString(entries[i].tx.Id) < hex.EncodeToString(entries[j].tx.Id)
+	})
+
+	return entries
+}
+
+//higherFeeRate 比较a.fee / a.size和b.fee / b.size
+func higherFeeRate(a, b *entry) bool {
+	return a.fee * b.size > b.fee * a.size
+}
+
+func outpointKey(txId []byte, out int) string {
+	return fmt.Sprintf("%x:%d", txId, out)
+}
\ No newline at end of file
@@ -0,0 +1,225 @@
+package mempool
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/block"
+	"github.com/pylrichard/building_block_chain_in_go/simple/transaction"
+	"github.com/pylrichard/building_block_chain_in_go/simple/utxo"
+	"github.com/pylrichard/building_block_chain_in_go/simple/wallet"
+)
+
+func TestPoolRejectsConflicts(t *testing.T) {
+	from := wallet.NewWallet()
+	to := wallet.NewWallet()
+	bc := block.NewChainWithGenesis(string(from.GetAddr()), t.TempDir(), "mempool_test")
+	defer bc.Db.Close()
+
+	set := utxo.Set{Chain: bc}
+	set.Reindex()
+	p := NewPool(set, 1 << 20)
+
+	tx, err := utxo.NewUTXOTransaction(from, string(to.GetAddr()), 1, 0, &set)
+	assert.Nil(t, err)
+	assert.Nil(t, p.Add(*tx))
+	assert.ErrorIs(t, p.Add(*tx), ErrAlreadyExists)
+
+	fee, ok := p.Fee(tx.Id)
+	assert.True(t, ok)
+	assert.Equal(t, 0, fee)
+
+	conflict, err := utxo.NewUTXOTransaction(from, string(to.GetAddr()), 2, 1, &set)
+	assert.Nil(t, err)
+	assert.ErrorIs(t, p.Add(*conflict), ErrDoubleSpend)
+	assert.Equal(t, 1, p.Count())
+
+	p.Remove(tx.Id)
+	assert.Nil(t, p.Add(*conflict))
+	assert.False(t, p.Has(tx.Id))
+
+	//输出总额溢出后不能通过输入不少于输出的检查
+	p.Remove(conflict.Id)
+	out := transaction.NewTxOutput(1 << 62, string(to.GetAddr()))
+	overflow := &transaction.Transaction{
+		In:		[]transaction.TxInput{{TxId: tx.In[0].TxId, Out: tx.In[0].Out, PubKey: from.PublicKey}},
+		Out:	[]transaction.TxOutput{*out, *out, *out, *out},
+	}
+	overflow.Id = overflow.Hash()
+	bc.SignTransaction(overflow, from.PrivateKey)
+	assert.ErrorIs(t, p.Add(*overflow), transaction.ErrValueOutOfRange)
+	assert.ErrorIs(t, bc.ValidateTransaction(overflow), block.ErrInvalidValue)
+}
+
+//splitGenesis 挖出把genesis的输出平分为num个w的输出的区块，返回平分的交易
+func splitGenesis(t *testing.T, bc *block.Chain, w *wallet.Wallet, num int) (*transaction.Transaction, *block.Block) {
+	genesis, err := bc.GetBlockByHeight(0)
+	assert.Nil(t, err)
+	genesisCb := genesis.Transactions[0]
+
+	split := &transaction.Transaction{In: []transaction.TxInput{{TxId: genesisCb.Id, Out: 0, PubKey: w.PublicKey}}}
+	for i := 0; i < num; i++ {
+		split.Out = append(split.Out, *transaction.NewTxOutput(genesisCb.Out[0].Value / num, string(w.GetAddr())))
+	}
+	split.Id = split.Hash()
+	bc.SignTransaction(split, w.PrivateKey)
+
+	b, err := bc.MineBlock([]*transaction.Transaction{transaction.NewCoinBaseTx(string(w.GetAddr()), "", 1, 0), split})
+	assert.Nil(t, err)
+
+	return split, b
+}
+
+//spendOutput 返回w花费prev第out个输出、支付fee手续费的已签名交易
+func spendOutput(bc *block.Chain, w *wallet.Wallet, prev *transaction.Transaction, out, fee int, to string) *transaction.Transaction {
+	in := transaction.TxInput{TxId: prev.Id, Out: out, PubKey: w.PublicKey}
+	tx := &transaction.Transaction{In: []transaction.TxInput{in}, Out: []transaction.TxOutput{*transaction.NewTxOutput(prev.Out[out].Value - fee, to)}}
+	tx.Id = tx.Hash()
+	bc.SignTransaction(tx, w.PrivateKey)
+
+	return tx
+}
+
+//mineChild 在parent之后挖出只包含Coinbase交易的区块，不保存
+func mineChild(addr string, parent *block.Block) *block.Block {
+	b := &block.Block{
+		Timestamp:		parent.Timestamp + 1,
+		Transactions:	[]*transaction.Transaction{transaction.NewCoinBaseTx(addr, "", parent.Height + 1, 0)},
+		PrevBlockHash:	parent.Hash,
+		Height:			parent.Height + 1,
+		Bits:			parent.Bits,
+	}
+	b.MerkleRoot = b.HashTransaction()
+	b.Nonce, b.Hash = block.NewProofOfWork(b).Run()
+
+	return b
+}
+
+func TestPoolOrdersByFeeRate(t *testing.T) {
+	from := wallet.NewWallet()
+	to := string(wallet.NewWallet().GetAddr())
+	bc := block.NewChainWithGenesis(string(from.GetAddr()), t.TempDir(), "mempool_test")
+	defer bc.Db.Close()
+
+	split, _ := splitGenesis(t, bc, from, 3)
+	p := NewPool(utxo.Set{Chain: bc}, 1 << 20)
+
+	low := spendOutput(bc, from, split, 0, 0, to)
+	high := spendOutput(bc, from, split, 1, 2, to)
+	mid := spendOutput(bc, from, split, 2, 1, to)
+	assert.Nil(t, p.Add(*low))
+	assert.Nil(t, p.Add(*high))
+	assert.Nil(t, p.Add(*mid))
+
+	txs := p.Transactions()
+	assert.Len(t, txs, 3)
+	assert.Equal(t, [][]byte{high.Id, mid.Id, low.Id}, [][]byte{txs[0].Id, txs[1].Id, txs[2].Id})
+
+	txs, fees := p.BlockTemplate(1 << 20)
+	assert.Len(t, txs, 3)
+	assert.Equal(t, 3, fees)
+
+	//区块只能放下一笔交易时选择费率最高的
+	txs, fees = p.BlockTemplate(len(high.Serialize()))
+	assert.Len(t, txs, 1)
+	assert.Equal(t, high.Id, txs[0].Id)
+	assert.Equal(t, 2, fees)
+}
+
+func TestPoolEvictsLowestFeeRate(t *testing.T) {
+	from := wallet.NewWallet()
+	to := string(wallet.NewWallet().GetAddr())
+	bc := block.NewChainWithGenesis(string(from.GetAddr()), t.TempDir(), "mempool_test")
+	defer bc.Db.Close()
+
+	split, _ := splitGenesis(t, bc, from, 4)
+	first := spendOutput(bc, from, split, 0, 1, to)
+	second := spendOutput(bc, from, split, 1, 2, to)
+	cheap := spendOutput(bc, from, split, 2, 0, to)
+	rich := spendOutput(bc, from, split, 3, 2, to)
+
+	//mempool只能放下两笔交易
+	size := len(first.Serialize()) + len(second.Serialize()) + len(rich.Serialize()) / 2
+	p := NewPool(utxo.Set{Chain: bc}, size)
+	assert.Nil(t, p.Add(*first))
+	assert.Nil(t, p.Add(*second))
+
+	assert.ErrorIs(t, p.Add(*cheap), ErrPoolFull)
+	assert.Equal(t, 2, p.Count())
+
+	assert.Nil(t, p.Add(*rich))
+	assert.Equal(t, 2, p.Count())
+	assert.False(t, p.Has(first.Id))
+	assert.True(t, p.Has(second.Id))
+	assert.True(t, p.Has(rich.Id))
+
+	//被驱逐的交易花费的输出可以被其他交易花费
+	p.Remove(second.Id)
+	assert.Nil(t, p.Add(*spendOutput(bc, from, split, 0, 2, to)))
+}
+
+func TestPoolApplyChainUpdate(t *testing.T) {
+	from := wallet.NewWallet()
+	to := string(wallet.NewWallet().GetAddr())
+	bc := block.NewChainWithGenesis(string(from.GetAddr()), t.TempDir(), "mempool_test")
+	defer bc.Db.Close()
+
+	split, splitBlock := splitGenesis(t, bc, from, 2)
+	p := NewPool(utxo.Set{Chain: bc}, 1 << 20)
+
+	tx := spendOutput(bc, from, split, 0, 1, to)
+	pending := spendOutput(bc, from, split, 1, 1, to)
+	conflict := spendOutput(bc, from, split, 1, 2, to)
+	assert.Nil(t, p.Add(*tx))
+	assert.Nil(t, p.Add(*pending))
+
+	//区块包含tx和与pending冲突的交易，两者都从mempool中删除
+	mined, err := bc.MineBlock([]*transaction.Transaction{transaction.NewCoinBaseTx(to, "", 2, 3), tx, conflict})
+	assert.Nil(t, err)
+	p.ApplyChainUpdate(&block.ChainUpdate{Connected: []*block.Block{mined}})
+	assert.Equal(t, 0, p.Count())
+
+	//更长的分支断开mined后，它的交易回到mempool
+	fork := splitBlock
+	for i := 0; i < 2; i++ {
+		fork = mineChild(to, fork)
+		update, err := bc.AddBlock(fork)
+		assert.Nil(t, err)
+		p.ApplyChainUpdate(update)
+	}
+
+	assert.Equal(t, 2, p.Count())
+	assert.True(t, p.Has(tx.Id))
+	assert.True(t, p.Has(conflict.Id))
+	assert.False(t, p.Has(pending.Id))
+}
+
+func TestPoolRevalidatesAfterDisconnect(t *testing.T) {
+	from := wallet.NewWallet()
+	to := string(wallet.NewWallet().GetAddr())
+	bc := block.NewChainWithGenesis(string(from.GetAddr()), t.TempDir(), "mempool_test")
+	defer bc.Db.Close()
+
+	genesis, err := bc.GetBlockByHeight(0)
+	assert.Nil(t, err)
+	split, _ := splitGenesis(t, bc, from, 2)
+	p := NewPool(utxo.Set{Chain: bc}, 1 << 20)
+
+	tx := spendOutput(bc, from, split, 0, 1, to)
+	assert.Nil(t, p.Add(*tx))
+
+	//从genesis分叉的更长分支断开包含split的区块，tx花费的输出不再存在
+	fork := &genesis
+	for i := 0; i < 2; i++ {
+		fork = mineChild(to, fork)
+		update, err := bc.AddBlock(fork)
+		assert.Nil(t, err)
+		p.ApplyChainUpdate(update)
+	}
+	assert.Equal(t, 2, bc.GetBestHeight())
+
+	assert.False(t, p.Has(tx.Id))
+	assert.True(t, p.Has(split.Id))
+	assert.Equal(t, 1, p.Count())
+}
\ No newline at end of file
@@ -0,0 +1,306 @@
+package server
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const peersFileTemplate = "peers_%s.dat"
+//maxKnownAddrs 最多保存的地址数量，超过时丢弃最差的地址
+const maxKnownAddrs = 1000
+//maxAddrPerMsg 一条addr消息最多包含的地址数量
+const maxAddrPerMsg = 1000
+//maxAddrRelay 每次发送给其他节点的地址数量
+const maxAddrRelay = 100
+//addrRelayHorizon 只转发最近见过的地址
+const addrRelayHorizon = 3 * time.Hour
+//maxAddrFailures 从未连接成功的地址连续失败该次数后丢弃
+const maxAddrFailures = 5
+const retryBaseInterval = 30 * time.Second
+const retryMaxInterval = time.Hour
+
+//knownAddr 记录一个节点地址的连接情况
+type knownAddr struct {
+	Addr		string
+	LastSeen	time.Time
+	LastAttempt	time.Time
+	LastSuccess	time.Time
+	Failures	int
+}
+
+//addrManager 保存去重后的节点地址，持久化到数据目录，用于选择出站连接和转发addr
+type addrManager struct {
+	lock	sync.Mutex
+	file	string
+	//self 本节点的地址，不会被加入
+	self	string
+	addrs	map[string]*knownAddr
+	dirty	bool
+}
+
+//newAddrManager dataDir为空时不持久化
+func newAddrManager(dataDir, nodeId, self string) *addrManager {
+	file := ""
+	if dataDir != "" {
+		file = filepath.Join(dataDir, fmt.Sprintf(peersFileTemplate, nodeId))
+	}
+
+	return &addrManager{file: file, self: self, addrs: make(map[string]*knownAddr)}
+}
+
+//Add 加入格式正确的新地址，已知地址只更新LastSeen，返回新加入的数量
+func (am *addrManager) Add(addrs ...string) int {
+	am.lock.Lock()
+	defer am.lock.Unlock()
+
+	now := time.Now()
+	added := 0
+
+	for _, addr := range addrs {
+		if addr == am.self || !isValidAddr(addr) {
+			continue
+		}
+
+		if ka, ok := am.addrs[addr]; ok {
+			ka.LastSeen = now
+			continue
+		}
+
+		if len(am.addrs) >= maxKnownAddrs {
+			am.evict()
+		}
+		am.addrs[addr] = &knownAddr{Addr: addr, LastSeen: now}
+		added++
+	}
+	am.dirty = true
+
+	return added
+}
+
+//Attempt 记录开始连接addr
+func (am *addrManager) Attempt(addr string) {
+	am.update(addr, func(ka *knownAddr) {
+		ka.LastAttempt = time.Now()
+	})
+}
+
+//Good 记录和addr连接成功
+func (am *addrManager) Good(addr string) {
+	am.update(addr, func(ka *knownAddr) {
+		now := time.Now()
+		ka.LastSeen = now
+		ka.LastSuccess = now
+		ka.Failures = 0
+	})
+}
+
+//Failed 记录连接addr失败，从未连接成功的地址失败多次后被丢弃
+func (am *addrManager) Failed(addr string) {
+	am.lock.Lock()
+	defer am.lock.Unlock()
+
+	ka, ok := am.addrs[addr]
+	if !ok {
+		return
+	}
+
+	ka.Failures++
+	if ka.LastSuccess.IsZero() && ka.Failures >= maxAddrFailures {
+		delete(am.addrs, addr)
+	}
+	am.dirty = true
+}
+
+//Remove 丢弃addr，用于发现addr是本节点的地址时
+func (am *addrManager) Remove(addr string) {
+	am.lock.Lock()
+	defer am.lock.Unlock()
+
+	delete(am.addrs, addr)
+	am.dirty = true
+}
+
+func (am *addrManager) update(addr string, f func(ka *knownAddr)) {
+	am.lock.Lock()
+	defer am.lock.Unlock()
+
+	ka, ok := am.addrs[addr]
+	if !ok {
+		return
+	}
+
+	f(ka)
+	am.dirty = true
+}
+
+func (am *addrManager) Count() int {
+	am.lock.Lock()
+	defer am.lock.Unlock()
+
+	return len(am.addrs)
+}
+
+//ForRelay 随机返回最多max个最近见过且没有连续失败的地址
+func (am *addrManager) ForRelay(max int) []string {
+	am.lock.Lock()
+	defer am.lock.Unlock()
+
+	var addrs []string
+	horizon := time.Now().Add(-addrRelayHorizon)
+
+	for addr, ka := range am.addrs {
+		if ka.LastSeen.After(horizon) && ka.Failures == 0 {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	rand.Shuffle(len(addrs), func(i, j int) {
+		addrs[i], addrs[j] = addrs[j], addrs[i]
+	})
+	if len(addrs) > max {
+		addrs = addrs[:max]
+	}
+
+	return addrs
+}
+
+//Candidates 返回最多max个可以连接的地址，跳过skip中的地址和还在重试间隔内的地址
+//失败次数少的优先，其次是最近见过的
+func (am *addrManager) Candidates(max int, skip func(addr string) bool) []string {
+	am.lock.Lock()
+	defer am.lock.Unlock()
+
+	var candidates []*knownAddr
+	now := time.Now()
+
+	for addr, ka := range am.addrs {
+		if skip(addr) || now.Before(ka.LastAttempt.Add(retryInterval(ka.Failures))) {
+			continue
+		}
+		candidates = append(candidates, ka)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Failures != candidates[j].Failures {
+			return candidates[i].Failures < candidates[j].Failures
+		}
+
+		return candidates[i].LastSeen.After(candidates[j].LastSeen)
+	})
+
+	var addrs []string
+	for i := 0; i < len(candidates) && i < max; i++ {
+		addrs = append(addrs, candidates[i].Addr)
+	}
+
+	return addrs
+}
+
+//evict 丢弃失败次数最多、其次最久没有见过的地址，调用者需要持有am.lock
+func (am *addrManager) evict() {
+	var worst *knownAddr
+
+	for _, ka := range am.addrs {
+		if worst == nil || ka.Failures > worst.Failures ||
+			(ka.Failures == worst.Failures && ka.LastSeen.Before(worst.LastSeen)) {
+			worst = ka
+		}
+	}
+
+	if worst != nil {
+		delete(am.addrs, worst.Addr)
+	}
+}
+
+//Load 从数据目录加载地址，文件不存在时不做任何事
+func (am *addrManager) Load() error {
+	if am.file == "" {
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(am.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var addrs []knownAddr
+	decoder := gob.NewDecoder(bytes.NewReader(content))
+	err = decoder.Decode(&addrs)
+	if err != nil {
+		return err
+	}
+
+	am.lock.Lock()
+	defer am.lock.Unlock()
+
+	for i := range addrs {
+		ka := addrs[i]
+		if ka.Addr == am.self || !isValidAddr(ka.Addr) || len(am.addrs) >= maxKnownAddrs {
+			continue
+		}
+		am.addrs[ka.Addr] = &ka
+	}
+
+	return nil
+}
+
+//Save 地址有变化时写入数据目录
+func (am *addrManager) Save() error {
+	am.lock.Lock()
+	if am.file == "" || !am.dirty {
+		am.lock.Unlock()
+
+		return nil
+	}
+
+	var addrs []knownAddr
+	for _, ka := range am.addrs {
+		addrs = append(addrs, *ka)
+	}
+	am.dirty = false
+	am.lock.Unlock()
+
+	var content bytes.Buffer
+	encoder := gob.NewEncoder(&content)
+	err := encoder.Encode(addrs)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(am.file, content.Bytes(), 0600)
+}
+
+//retryInterval 连续失败后重试的间隔按指数增长
+func retryInterval(failures int) time.Duration {
+	if failures == 0 {
+		return 0
+	}
+
+	interval := retryBaseInterval
+	for i := 1; i < failures && interval < retryMaxInterval; i++ {
+		interval *= 2
+	}
+	if interval > retryMaxInterval {
+		interval = retryMaxInterval
+	}
+
+	return interval
+}
+
+func isValidAddr(addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+
+	return err == nil && host != "" && port != "" && port != "0"
+}
\ No newline at end of file
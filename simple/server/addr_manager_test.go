@@ -0,0 +1,34 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddrManager(t *testing.T) {
+	dir := t.TempDir()
+	am := newAddrManager(dir, "3000", "localhost:3000")
+
+	assert.Equal(t, 2, am.Add("localhost:3001", "localhost:3001", "localhost:3000", "bad", "localhost:3002"))
+	assert.Equal(t, 2, am.Count())
+	assert.Len(t, am.ForRelay(1), 1)
+
+	//连接失败后在重试间隔内不再选择，多次失败后丢弃
+	am.Attempt("localhost:3002")
+	am.Failed("localhost:3002")
+	none := func(string) bool { return false }
+	assert.Equal(t, []string{"localhost:3001"}, am.Candidates(8, none))
+	for i := 1; i < maxAddrFailures; i++ {
+		am.Failed("localhost:3002")
+	}
+	assert.Equal(t, 1, am.Count())
+
+	am.Good("localhost:3001")
+	assert.Nil(t, am.Save())
+
+	loaded := newAddrManager(dir, "3000", "localhost:3000")
+	assert.Nil(t, loaded.Load())
+	assert.Equal(t, []string{"localhost:3001"}, loaded.Candidates(8, none))
+	assert.Empty(t, loaded.Candidates(8, func(addr string) bool { return addr == "localhost:3001" }))
+}
\ No newline at end of file
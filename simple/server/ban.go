@@ -0,0 +1,101 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+//节点的累计分数达到banThreshold时断开连接并禁止banDuration
+const banThreshold = 100
+const banDuration = 24 * time.Hour
+
+const (
+	scoreMalformedMessage	= 50
+	scoreUnknownCommand		= 10
+	scoreInvalidTx			= 20
+	scoreInvalidBlock		= 100
+	scoreHandlerPanic		= 50
+	scoreProtocolViolation	= 10
+)
+
+//peerError 表示对方节点发送了无效内容，score累加到对方的分数上
+type peerError struct {
+	score	int
+	err		error
+}
+
+func (e *peerError) Error() string {
+	return e.err.Error()
+}
+
+func misbehaving(score int, err error) error {
+	return &peerError{score, err}
+}
+
+//banKey 按连接的对方IP计分和禁止，对方在version中声明的地址可以伪造，不能用于计分
+func banKey(p *peer) string {
+	return remoteHost(p.conn)
+}
+
+func remoteHost(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+
+	return host
+}
+
+//punishPeer 累加err对应的分数，返回节点是否因此被禁止
+func (n *Node) punishPeer(p *peer, err error) bool {
+	var pe *peerError
+	if !errors.As(err, &pe) {
+		return false
+	}
+
+	key := banKey(p)
+
+	n.banLock.Lock()
+	defer n.banLock.Unlock()
+
+	n.banScores[key] += pe.score
+	if n.banScores[key] < banThreshold {
+		return false
+	}
+
+	delete(n.banScores, key)
+	n.bannedUntil[key] = time.Now().Add(banDuration)
+	fmt.Printf("Banned %s until %s\n", key, n.bannedUntil[key].Format(time.RFC3339))
+
+	return true
+}
+
+//addrHost 返回addr中的host，用于在连接addr之前检查它是否被禁止
+func addrHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
+
+//isBanned 返回IP是否被禁止
+func (n *Node) isBanned(key string) bool {
+	n.banLock.Lock()
+	defer n.banLock.Unlock()
+
+	until, ok := n.bannedUntil[key]
+	if !ok {
+		return false
+	}
+	if time.Now().After(until) {
+		delete(n.bannedUntil, key)
+
+		return false
+	}
+
+	return true
+}
\ No newline at end of file
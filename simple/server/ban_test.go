@@ -0,0 +1,72 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//tcpPipe 返回通过127.0.0.1连接的两端，net.Pipe的连接没有IP
+func tcpPipe(t *testing.T) (net.Conn, net.Conn) {
+	l, err := net.Listen(protocol, "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+
+	remote, err := net.Dial(protocol, l.Addr().String())
+	assert.Nil(t, err)
+	local, err := l.Accept()
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		local.Close()
+		remote.Close()
+	})
+
+	return local, remote
+}
+
+func TestPunishPeer(t *testing.T) {
+	n := newTestNode(t)
+	local, _ := tcpPipe(t)
+	//声明的地址不影响计分
+	p := &peer{conn: local, addr: "10.0.0.1:3000"}
+
+	assert.False(t, n.punishPeer(p, errors.New("connection reset")))
+	for i := 0; i < banThreshold / scoreInvalidTx - 1; i++ {
+		assert.False(t, n.punishPeer(p, misbehaving(scoreInvalidTx, errors.New("invalid tx"))))
+	}
+	assert.False(t, n.isBanned("127.0.0.1"))
+
+	assert.True(t, n.punishPeer(p, misbehaving(scoreInvalidTx, errors.New("invalid tx"))))
+	assert.True(t, n.isBanned("127.0.0.1"))
+	assert.True(t, n.isBanned(addrHost("127.0.0.1:3001")))
+	assert.False(t, n.isBanned(addrHost(p.addr)))
+
+	//同一IP声明其他地址的连接也被禁止
+	other, _ := tcpPipe(t)
+	assert.True(t, n.isBanned(banKey(&peer{conn: other, addr: "10.0.0.2:3000"})))
+
+	n.banLock.Lock()
+	n.bannedUntil["127.0.0.1"] = time.Now().Add(-time.Second)
+	n.banLock.Unlock()
+	assert.False(t, n.isBanned("127.0.0.1"))
+}
+
+func TestBannedPeerIsDisconnected(t *testing.T) {
+	n := newTestNode(t)
+	local, remote := tcpPipe(t)
+	go n.handleConnection(&peer{conn: local})
+	remote.SetDeadline(time.Now().Add(5 * time.Second))
+
+	n.banLock.Lock()
+	n.bannedUntil["127.0.0.1"] = time.Now().Add(banDuration)
+	n.banLock.Unlock()
+
+	version := Version{nodeVersion, 0, time.Now().Unix(), 0, "10.0.0.1:3000", userAgent, randomNonce()}
+	assert.Nil(t, writeMessage(remote, "version", encodePayload(&version)))
+
+	_, _, err := readMessage(remote)
+	assert.NotNil(t, err)
+}
\ No newline at end of file
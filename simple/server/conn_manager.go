@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+//connCheckInterval 连接管理器检查出站连接数量和保存地址的间隔
+const connCheckInterval = 10 * time.Second
+
+//connLoop 保持targetOutbound个出站连接，直到ctx被取消
+func (n *Node) connLoop(ctx context.Context) {
+	ticker := time.NewTicker(connCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		n.connectPeers(ctx)
+
+		err := n.addrMgr.Save()
+		if err != nil {
+			fmt.Printf("Failed to save peer addresses: %s\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//connectPeers 出站连接不足时从地址管理器选择地址建立连接，没有出站连接时重新加入种子节点
+func (n *Node) connectPeers(ctx context.Context) {
+	outbound := n.outboundCount()
+	if outbound >= n.targetOutbound {
+		return
+	}
+	if outbound == 0 {
+		n.addrMgr.Add(n.seeds...)
+	}
+
+	skip := func(addr string) bool {
+		return n.isConnected(addr) || n.isBanned(addrHost(addr))
+	}
+
+	for _, addr := range n.addrMgr.Candidates(n.targetOutbound - outbound, skip) {
+		if ctx.Err() != nil {
+			return
+		}
+
+		_, err := n.getPeer(addr)
+		if err != nil {
+			fmt.Printf("%s is not available\n", addr)
+		}
+	}
+}
\ No newline at end of file
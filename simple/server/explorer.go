@@ -0,0 +1,306 @@
+package server
+
+import (
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/transaction"
+)
+
+//区块浏览器：只读的REST API和一个调用它的HTML页面，不需要认证
+//GET /api/status                        链和节点的状态
+//GET /api/blocks?start=HEIGHT&count=N   从start往下的N个主链区块，默认从tip开始
+//GET /api/block/HASH或HEIGHT            区块和其中的交易
+//GET /api/tx/TXID                       交易，先在mempool中查找
+//GET /api/address/ADDR                  地址的余额、UTXO和交易历史，历史需要启用地址索引
+//GET /api/mempool                       mempool中的交易
+
+const defaultBlocksPerPage = 20
+const maxBlocksPerPage = 100
+//maxAddrHistory 地址交易历史最多返回的交易数量，按高度从高到低
+const maxAddrHistory = 100
+
+//go:embed explorer/index.html
+var explorerPage []byte
+
+type StatusResult struct {
+	Height		int		`json:"height"`
+	BestHash	string	`json:"best_hash"`
+	MempoolSize	int		`json:"mempool_size"`
+	Peers		int		`json:"peers"`
+	//Indexed 是否启用了交易索引和地址索引
+	Indexed		bool	`json:"indexed"`
+}
+
+type BlockSummary struct {
+	Hash		string	`json:"hash"`
+	Height		int		`json:"height"`
+	Timestamp	int64	`json:"timestamp"`
+	Bits		int		`json:"bits"`
+	TxCount		int		`json:"tx_count"`
+}
+
+type AddressResult struct {
+	Addr			string			`json:"addr"`
+	Balance			int				`json:"balance"`
+	Unspent			[]UnspentResult	`json:"unspent"`
+	//Indexed 没有启用地址索引时Transactions只包含mempool中的交易
+	Indexed			bool			`json:"indexed"`
+	//TxCount 主链和mempool中和地址有关的交易数量，可能多于Transactions
+	TxCount			int				`json:"tx_count"`
+	Transactions	[]TxResult		`json:"txs"`
+}
+
+//explorerHandler 返回区块浏览器的路由
+func (n *Node) explorerHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/status", n.explorerAPI(n.handleStatus))
+	mux.HandleFunc("/api/blocks", n.explorerAPI(n.handleBlocks))
+	mux.HandleFunc("/api/block/", n.explorerAPI(n.handleBlockInfo))
+	mux.HandleFunc("/api/tx/", n.explorerAPI(n.handleTxInfo))
+	mux.HandleFunc("/api/address/", n.explorerAPI(n.handleAddrInfo))
+	mux.HandleFunc("/api/mempool", n.explorerAPI(n.handleMempool))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(explorerPage)
+	})
+
+	return mux
+}
+
+//apiError 带HTTP状态码的错误，以{"error": "..."}返回
+type apiError struct {
+	status	int
+	message	string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+//explorerAPI 只接受GET，把handler的结果编码为JSON
+func (n *Node) explorerAPI(handler func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", http.MethodGet)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+
+			return
+		}
+
+		result, err := handler(r)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if e, ok := err.(*apiError); ok {
+				status = e.status
+			}
+			writeJSON(w, status, map[string]string{"error": err.Error()})
+
+			return
+		}
+
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+func (n *Node) handleStatus(r *http.Request) (interface{}, error) {
+	height := n.bc.GetBestHeight()
+	tip, err := n.bc.GetBlockByHeight(height)
+	if err != nil {
+		return nil, err
+	}
+
+	return StatusResult{
+		Height:			height,
+		BestHash:		hex.EncodeToString(tip.Hash),
+		MempoolSize:	n.memPool.Count(),
+		Peers:			len(n.peerAddrs()),
+		Indexed:		n.bc.HasIndexes(),
+	}, nil
+}
+
+func (n *Node) handleBlocks(r *http.Request) (interface{}, error) {
+	best := n.bc.GetBestHeight()
+
+	start, err := queryInt(r, "start", best)
+	if err != nil {
+		return nil, err
+	}
+	count, err := queryInt(r, "count", defaultBlocksPerPage)
+	if err != nil {
+		return nil, err
+	}
+	if start > best {
+		start = best
+	}
+	if count > maxBlocksPerPage {
+		count = maxBlocksPerPage
+	}
+
+	blocks := []BlockSummary{}
+	for height := start; height >= 0 && len(blocks) < count; height-- {
+		b, err := n.bc.GetBlockByHeight(height)
+		if err != nil {
+			return nil, err
+		}
+
+		blocks = append(blocks, BlockSummary{hex.EncodeToString(b.Hash), b.Height, b.Timestamp, b.Bits, len(b.Transactions)})
+	}
+
+	return blocks, nil
+}
+
+//handleBlockInfo 参数是不超过10位的数字时按主链高度查找，否则按哈希查找
+func (n *Node) handleBlockInfo(r *http.Request) (interface{}, error) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/block/")
+
+	if height, err := strconv.Atoi(id); err == nil && len(id) <= 10 {
+		b, err := n.bc.GetBlockByHeight(height)
+		if err != nil {
+			return nil, &apiError{http.StatusNotFound, fmt.Sprintf("block at height %d is not found", height)}
+		}
+
+		return n.newBlockResult(&b), nil
+	}
+
+	hash, err := hex.DecodeString(id)
+	if err != nil || len(hash) == 0 {
+		return nil, &apiError{http.StatusBadRequest, fmt.Sprintf("%q is not a valid block hash or height", id)}
+	}
+
+	b, err := n.bc.GetBlock(hash)
+	if err != nil {
+		return nil, &apiError{http.StatusNotFound, fmt.Sprintf("block %s is not found", id)}
+	}
+
+	return n.newBlockResult(&b), nil
+}
+
+func (n *Node) handleTxInfo(r *http.Request) (interface{}, error) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/tx/")
+
+	txId, err := hex.DecodeString(id)
+	if err != nil || len(txId) == 0 {
+		return nil, &apiError{http.StatusBadRequest, fmt.Sprintf("%q is not a valid transaction id", id)}
+	}
+
+	tx, height, ok := n.findTransaction(txId)
+	if !ok {
+		return nil, &apiError{http.StatusNotFound, fmt.Sprintf("transaction %s is not found", id)}
+	}
+
+	return n.newTxResultAt(&tx, height), nil
+}
+
+//handleAddrInfo mempool中的交易排在最前面，然后是主链上的交易，按高度从高到低
+func (n *Node) handleAddrInfo(r *http.Request) (interface{}, error) {
+	addr := strings.TrimPrefix(r.URL.Path, "/api/address/")
+
+	pubKeyHash, err := decodeAddrParam(addr)
+	if err != nil {
+		return nil, &apiError{http.StatusBadRequest, fmt.Sprintf("%q is not a valid address", addr)}
+	}
+
+	result := AddressResult{
+		Addr:			addr,
+		Unspent:		n.unspentResults(pubKeyHash),
+		Indexed:		n.bc.HasIndexes(),
+		Transactions:	[]TxResult{},
+	}
+	for _, out := range result.Unspent {
+		result.Balance += out.Value
+	}
+
+	for _, tx := range n.memPool.Transactions() {
+		if isAddrTransaction(tx, pubKeyHash) {
+			result.Transactions = append(result.Transactions, n.newTxResultAt(tx, -1))
+		}
+	}
+
+	var history []TxResult
+	if result.Indexed {
+		txIds, err := n.bc.FindAddressTransactions(pubKeyHash)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, txId := range txIds {
+			tx, height, err := n.bc.FindMainChainTransaction(txId)
+			if err != nil {
+				continue
+			}
+			history = append(history, n.newTxResultAt(&tx, height))
+		}
+
+		sort.SliceStable(history, func(i, j int) bool {
+			return history[i].Height > history[j].Height
+		})
+	}
+
+	result.TxCount = len(result.Transactions) + len(history)
+	for i := 0; i < len(history) && len(result.Transactions) < maxAddrHistory; i++ {
+		result.Transactions = append(result.Transactions, history[i])
+	}
+
+	return result, nil
+}
+
+func (n *Node) handleMempool(r *http.Request) (interface{}, error) {
+	return n.mempoolEntries(), nil
+}
+
+//isAddrTransaction 返回tx是否花费或支付给pubKeyHash
+func isAddrTransaction(tx *transaction.Transaction, pubKeyHash []byte) bool {
+	for _, out := range tx.Out {
+		if out.IsLockedWithKey(pubKeyHash) {
+			return true
+		}
+	}
+
+	for _, in := range tx.In {
+		if !tx.IsCoinBase() && in.IsKeyUsed(pubKeyHash) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//queryInt 返回查询参数name的值，没有设置时返回def
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil || i < 0 {
+		return 0, &apiError{http.StatusBadRequest, fmt.Sprintf("%s must be a non-negative integer", name)}
+	}
+
+	return i, nil
+}
+
+//writeJSON 以status返回v的JSON编码，JSON-RPC和区块浏览器共用
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		fmt.Printf("Failed to write HTTP response: %s\n", err)
+	}
+}
\ No newline at end of file
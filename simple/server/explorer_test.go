@@ -0,0 +1,74 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/utxo"
+	"github.com/pylrichard/building_block_chain_in_go/simple/wallet"
+)
+
+func TestExplorer(t *testing.T) {
+	n := newTestNode(t)
+	utxo.Set{Chain: n.bc}.Reindex()
+	genesis, err := n.bc.GetBlockByHeight(0)
+	assert.Nil(t, err)
+	addr := string(wallet.PubKeyHashToAddr(genesis.Transactions[0].Out[0].PubKeyHash))
+	handler := n.explorerHandler()
+
+	get := func(path string, v interface{}) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if v != nil {
+			assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), v))
+		}
+
+		return rec.Code
+	}
+
+	var status StatusResult
+	assert.Equal(t, http.StatusOK, get("/api/status", &status))
+	assert.Equal(t, 0, status.Height)
+
+	var b BlockResult
+	assert.Equal(t, http.StatusOK, get("/api/block/0", &b))
+	assert.Equal(t, status.BestHash, b.Hash)
+	assert.Equal(t, http.StatusOK, get("/api/block/" + b.Hash, &b))
+	assert.Equal(t, http.StatusNotFound, get("/api/block/1", nil))
+	assert.Equal(t, http.StatusBadRequest, get("/api/block/xyz", nil))
+
+	var tx TxResult
+	assert.Equal(t, http.StatusOK, get("/api/tx/" + b.Transactions[0].TxId, &tx))
+	assert.Equal(t, 1, tx.Confirmations)
+
+	//没有启用地址索引时只返回余额和UTXO
+	var a AddressResult
+	assert.Equal(t, http.StatusOK, get("/api/address/" + addr, &a))
+	assert.Equal(t, tx.Outputs[0].Value, a.Balance)
+	assert.False(t, a.Indexed)
+	assert.Empty(t, a.Transactions)
+
+	_, err = n.bc.RebuildIndexes()
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, get("/api/address/" + addr, &a))
+	assert.True(t, a.Indexed)
+	assert.Equal(t, 1, a.TxCount)
+	assert.Equal(t, tx.TxId, a.Transactions[0].TxId)
+
+	var blocks []BlockSummary
+	assert.Equal(t, http.StatusOK, get("/api/blocks?count=5", &blocks))
+	assert.Len(t, blocks, 1)
+	assert.Equal(t, http.StatusBadRequest, get("/api/blocks?count=-1", nil))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Contains(t, rec.Body.String(), "Block Explorer")
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/status", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
\ No newline at end of file
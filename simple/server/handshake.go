@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+//握手：发起连接的节点先发送version，双方收到version后回复verack
+//收到对方的version之前只处理version和verack，握手完成后定期发送ping检测连接
+
+//minProtocolVersion 支持的最低协议版本，之前的版本使用gob编码payload，区块哈希的计算方式也不同
+const minProtocolVersion = 3
+const userAgent = "/building_block_chain_in_go:0.3.0/"
+//handshakeTimeout 连接建立后必须在该时间内收到version
+const handshakeTimeout = 30 * time.Second
+const pingInterval = 30 * time.Second
+//pingTimeout 超过该时间没有收到pong时断开连接
+const pingTimeout = 2 * time.Minute
+
+//Service 节点提供的服务，在version中以位标志发送
+const (
+	//ServiceFullNode 保存完整的区块，可以提供任意区块
+	ServiceFullNode uint64 = 1 << iota
+	//ServicePruned 只保存最近的区块
+	ServicePruned
+	//ServiceMining 节点在挖矿
+	ServiceMining
+)
+
+var (
+	errSelfConnection		= errors.New("connected to self")
+	errObsoleteVersion		= errors.New("peer protocol version is too old")
+	errDuplicateConnection	= errors.New("already connected to peer")
+)
+
+type Ping struct {
+	Nonce uint64
+}
+
+type Pong struct {
+	Nonce uint64
+}
+
+//handshakeState 握手得到的对方节点信息和ping的状态
+type handshakeState struct {
+	lock			sync.Mutex
+	versionReceived	bool
+	verackReceived	bool
+	//version 双方协议版本中较小的一个
+	version			int
+	services		uint64
+	userAgent		string
+	startHeight		int
+	pingNonce		uint64
+	pingSent		time.Time
+	latency			time.Duration
+}
+
+//services 返回本节点提供的服务
+func (n *Node) services() uint64 {
+	services := ServiceFullNode
+	if len(n.miningAddr) > 0 {
+		services |= ServiceMining
+	}
+
+	return services
+}
+
+func (n *Node) newVersion() Version {
+	return Version{
+		Version:	nodeVersion,
+		Services:	n.services(),
+		Timestamp:	time.Now().Unix(),
+		BestHeight:	n.bc.GetBestHeight(),
+		AddrFrom:	n.addr,
+		UserAgent:	userAgent,
+		Nonce:		n.nonce,
+	}
+}
+
+func (n *Node) sendVersion(p *peer) error {
+	version := n.newVersion()
+
+	return p.send("version", encodePayload(&version))
+}
+
+func (n *Node) handleVerack(p *peer) error {
+	p.state.lock.Lock()
+	defer p.state.lock.Unlock()
+
+	if !p.state.versionReceived || p.state.verackReceived {
+		return misbehaving(scoreProtocolViolation, errors.New("unexpected verack"))
+	}
+	p.state.verackReceived = true
+	fmt.Printf("Handshake with %s completed, version %d, %s\n", p.conn.RemoteAddr(), p.state.version, p.state.userAgent)
+
+	return nil
+}
+
+func (n *Node) handlePing(p *peer, request []byte) error {
+	var payload Ping
+
+	err := decodePayload(request, &payload)
+	if err != nil {
+		return misbehaving(scoreMalformedMessage, err)
+	}
+
+	err = p.send("pong", encodePayload(&Pong{payload.Nonce}))
+	if err != nil {
+		n.removePeer(p)
+	}
+
+	return nil
+}
+
+//handlePong 忽略nonce不匹配的pong
+func (n *Node) handlePong(p *peer, request []byte) error {
+	var payload Pong
+
+	err := decodePayload(request, &payload)
+	if err != nil {
+		return misbehaving(scoreMalformedMessage, err)
+	}
+
+	p.state.lock.Lock()
+	defer p.state.lock.Unlock()
+
+	if p.state.pingNonce != 0 && payload.Nonce == p.state.pingNonce {
+		p.state.latency = time.Since(p.state.pingSent)
+		p.state.pingNonce = 0
+	}
+
+	return nil
+}
+
+//pingLoop 定期向握手完成的节点发送ping，断开超时没有回复的节点，直到ctx被取消
+func (n *Node) pingLoop(ctx context.Context) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n.pingPeers()
+		}
+	}
+}
+
+func (n *Node) pingPeers() {
+	for _, p := range n.getConns() {
+		p.state.lock.Lock()
+		ready := p.state.versionReceived && p.state.verackReceived
+		pending := p.state.pingNonce != 0
+		expired := pending && time.Since(p.state.pingSent) > pingTimeout
+		nonce := p.state.pingNonce
+		if ready && !pending {
+			nonce = randomNonce()
+			p.state.pingNonce = nonce
+			p.state.pingSent = time.Now()
+		}
+		p.state.lock.Unlock()
+
+		switch {
+		case !ready:
+			continue
+		case expired:
+			fmt.Printf("%s did not answer ping, disconnecting\n", p.addr)
+			n.removePeer(p)
+		case !pending:
+			err := p.send("ping", encodePayload(&Ping{nonce}))
+			if err != nil {
+				n.removePeer(p)
+			}
+		}
+	}
+}
+
+//dropSelfConnection 入站连接是本节点发起的连接时，断开对应的出站连接并丢弃它的地址
+func (n *Node) dropSelfConnection(inbound *peer) {
+	for _, p := range n.getPeers() {
+		if p.outbound && p.conn.LocalAddr().String() == inbound.conn.RemoteAddr().String() {
+			fmt.Printf("%s is the address of this node\n", p.addr)
+			n.addrMgr.Remove(p.addr)
+			n.removePeer(p)
+		}
+	}
+}
+
+func randomNonce() uint64 {
+	var b [8]byte
+
+	_, err := rand.Read(b[:])
+	if err != nil {
+		log.Panic(err)
+	}
+
+	//0表示没有等待回复的ping
+	nonce := binary.LittleEndian.Uint64(b[:])
+	if nonce == 0 {
+		nonce = 1
+	}
+
+	return nonce
+}
\ No newline at end of file
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/block"
+	"github.com/pylrichard/building_block_chain_in_go/simple/config"
+	"github.com/pylrichard/building_block_chain_in_go/simple/utxo"
+	"github.com/pylrichard/building_block_chain_in_go/simple/wallet"
+)
+
+func newTestNode(t *testing.T) *Node {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	cfg.Seeds = nil
+	assert.Nil(t, cfg.Validate())
+
+	bc := block.NewChainWithGenesis(string(wallet.NewWallet().GetAddr()), cfg.DataDir, cfg.NodeId)
+	t.Cleanup(func() { bc.Db.Close() })
+
+	return NewNode(cfg, bc)
+}
+
+func TestHandshake(t *testing.T) {
+	n := newTestNode(t)
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	go n.handleConnection(&peer{conn: local})
+	remote.SetDeadline(time.Now().Add(5 * time.Second))
+
+	version := Version{nodeVersion, 0, time.Now().Unix(), 0, "", userAgent, randomNonce()}
+	assert.Nil(t, writeMessage(remote, "version", encodePayload(&version)))
+
+	cmd, _, err := readMessage(remote)
+	assert.Nil(t, err)
+	assert.Equal(t, "version", cmd)
+	cmd, _, err = readMessage(remote)
+	assert.Nil(t, err)
+	assert.Equal(t, "verack", cmd)
+
+	assert.Nil(t, writeMessage(remote, "ping", encodePayload(&Ping{42})))
+	cmd, payload, err := readMessage(remote)
+	assert.Nil(t, err)
+	assert.Equal(t, "pong", cmd)
+	assert.Equal(t, encodePayload(&Pong{42}), payload)
+}
+
+func TestHandshakeRejectsSelfConnection(t *testing.T) {
+	n := newTestNode(t)
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	go n.handleConnection(&peer{conn: local})
+	remote.SetDeadline(time.Now().Add(5 * time.Second))
+
+	//version之前的消息被忽略
+	assert.Nil(t, writeMessage(remote, "addr", encodePayload(&Addr{})))
+
+	version := n.newVersion()
+	assert.Nil(t, writeMessage(remote, "version", encodePayload(&version)))
+
+	_, _, err := readMessage(remote)
+	assert.NotNil(t, err)
+}
+
+func TestDuplicateConnectionIsClosed(t *testing.T) {
+	n := newTestNode(t)
+	version := Version{nodeVersion, 0, time.Now().Unix(), 0, "10.0.0.1:3000", userAgent, randomNonce()}
+
+	first, firstRemote := tcpPipe(t)
+	n.serve(&peer{conn: first})
+	firstRemote.SetDeadline(time.Now().Add(5 * time.Second))
+	assert.Nil(t, writeMessage(firstRemote, "version", encodePayload(&version)))
+	cmd, _, err := readMessage(firstRemote)
+	assert.Nil(t, err)
+	assert.Equal(t, "version", cmd)
+
+	//声明同一监听地址的连接被立即断开，原来的连接不受影响
+	second, secondRemote := tcpPipe(t)
+	n.serve(&peer{conn: second})
+	secondRemote.SetDeadline(time.Now().Add(5 * time.Second))
+	assert.Nil(t, writeMessage(secondRemote, "version", encodePayload(&version)))
+	_, _, err = readMessage(secondRemote)
+	assert.NotNil(t, err)
+	assert.True(t, n.isConnected("10.0.0.1:3000"))
+	assert.Len(t, n.getConns(), 1)
+}
+
+func TestShutdownClosesAllConnections(t *testing.T) {
+	n := newTestNode(t)
+
+	//还没有发送version的入站连接不知道监听地址
+	local, remote := tcpPipe(t)
+	n.serve(&peer{conn: local})
+
+	done := make(chan struct{})
+	go func() {
+		n.Shutdown()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown did not close the connection")
+	}
+
+	remote.SetDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := readMessage(remote)
+	assert.NotNil(t, err)
+}
+
+func TestSendTxWaitsForHandshake(t *testing.T) {
+	from := wallet.NewWallet()
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	cfg.Seeds = nil
+	assert.Nil(t, cfg.Validate())
+
+	bc := block.NewChainWithGenesis(string(from.GetAddr()), cfg.DataDir, cfg.NodeId)
+	defer bc.Db.Close()
+	set := utxo.Set{Chain: bc}
+	set.Reindex()
+	n := NewNode(cfg, bc)
+	defer n.Shutdown()
+
+	l, err := net.Listen(protocol, "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			n.serve(&peer{conn: conn})
+		}
+	}()
+
+	tx, err := utxo.NewUTXOTransaction(from, string(wallet.NewWallet().GetAddr()), 1, 0, &set)
+	assert.Nil(t, err)
+	assert.Nil(t, SendTx(l.Addr().String(), tx))
+	assert.Eventually(t, func() bool { return n.memPool.Has(tx.Id) }, 5 * time.Second, 10 * time.Millisecond)
+
+	//对方没有完成握手就断开时返回错误
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	assert.NotNil(t, SendTx(l.Addr().String(), tx))
+}
\ No newline at end of file
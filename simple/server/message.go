@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+//消息格式：magic(4) + command(12) + payload长度(4，小端) + checksum(4) + payload
+const magicLen = 4
+const checksumLen = 4
+const headerLen = magicLen + cmdLen + 4 + checksumLen
+const maxPayloadLen = 32 << 20
+
+var magic = [magicLen]byte{0xb1, 0x0c, 0xc4, 0x1e}
+
+var (
+	errBadMagic		= errors.New("message magic is not correct")
+	errBadCommand	= errors.New("message command is malformed")
+	errBadChecksum	= errors.New("message checksum is not correct")
+	errOversized	= errors.New("message payload is too large")
+)
+
+//writeMessage 把cmd和payload封装成一条消息写入w
+func writeMessage(w io.Writer, cmd string, payload []byte) error {
+	if len(cmd) > cmdLen {
+		return errBadCommand
+	}
+	if len(payload) > maxPayloadLen {
+		return errOversized
+	}
+
+	header := make([]byte, headerLen)
+	copy(header, magic[:])
+	copy(header[magicLen:], cmdToBytes(cmd))
+	binary.LittleEndian.PutUint32(header[magicLen + cmdLen:], uint32(len(payload)))
+	copy(header[magicLen + cmdLen + 4:], checksum(payload))
+
+	_, err := w.Write(append(header, payload...))
+
+	return err
+}
+
+//readMessage 从r读取一条消息，在读取和解码payload前检查magic、command和长度
+func readMessage(r io.Reader) (string, []byte, error) {
+	header := make([]byte, headerLen)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if !bytes.Equal(header[:magicLen], magic[:]) {
+		return "", nil, errBadMagic
+	}
+
+	cmd, err := bytesToCmd(header[magicLen : magicLen + cmdLen])
+	if err != nil {
+		return "", nil, err
+	}
+
+	payloadLen := binary.LittleEndian.Uint32(header[magicLen + cmdLen:])
+	if payloadLen > maxPayloadLen {
+		return "", nil, errOversized
+	}
+
+	payload := make([]byte, payloadLen)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if !bytes.Equal(header[magicLen + cmdLen + 4:], checksum(payload)) {
+		return "", nil, errBadChecksum
+	}
+
+	return cmd, payload, nil
+}
+
+func cmdToBytes(cmd string) []byte {
+	var bytes [cmdLen]byte
+
+	for i, c := range cmd {
+		bytes[i] = byte(c)
+	}
+
+	return bytes[:]
+}
+
+//bytesToCmd 解析command，command由可打印ASCII字符组成，之后只能是0
+func bytesToCmd(bytes []byte) (string, error) {
+	var cmd []byte
+
+	for i, b := range bytes {
+		if b == 0x0 {
+			for _, rest := range bytes[i:] {
+				if rest != 0x0 {
+					return "", errBadCommand
+				}
+			}
+
+			break
+		}
+		if b < 0x20 || b > 0x7e {
+			return "", errBadCommand
+		}
+
+		cmd = append(cmd, b)
+	}
+	if len(cmd) == 0 {
+		return "", errBadCommand
+	}
+
+	return fmt.Sprintf("%s", cmd), nil
+}
+
+//isMalformed 返回readMessage的err是否由对方发送的不符合协议的消息引起，连接断开等传输错误返回false
+func isMalformed(err error) bool {
+	return err == errBadMagic || err == errBadCommand || err == errBadChecksum || err == errOversized
+}
+
+//checksum 返回payload两次SHA-256的前4个字节
+func checksum(payload []byte) []byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+
+	return second[:checksumLen]
+}
\ No newline at end of file
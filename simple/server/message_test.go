@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	var buff bytes.Buffer
+
+	assert.Nil(t, writeMessage(&buff, "version", []byte("first")))
+	assert.Nil(t, writeMessage(&buff, "tx", []byte("second")))
+
+	cmd, payload, err := readMessage(&buff)
+	assert.Nil(t, err)
+	assert.Equal(t, "version", cmd)
+	assert.Equal(t, []byte("first"), payload)
+
+	cmd, payload, err = readMessage(&buff)
+	assert.Nil(t, err)
+	assert.Equal(t, "tx", cmd)
+	assert.Equal(t, []byte("second"), payload)
+}
+
+func TestMessageRejected(t *testing.T) {
+	encode := func() []byte {
+		var buff bytes.Buffer
+		assert.Nil(t, writeMessage(&buff, "block", []byte("payload")))
+		return buff.Bytes()
+	}
+
+	data := encode()
+	data[0] ^= 0xff
+	_, _, err := readMessage(bytes.NewReader(data))
+	assert.Equal(t, errBadMagic, err)
+
+	data = encode()
+	data[magicLen + 6] = 'x'
+	_, _, err = readMessage(bytes.NewReader(data))
+	assert.Equal(t, errBadCommand, err)
+
+	data = encode()
+	data[len(data) - 1] ^= 0xff
+	_, _, err = readMessage(bytes.NewReader(data))
+	assert.Equal(t, errBadChecksum, err)
+
+	data = encode()
+	binary.LittleEndian.PutUint32(data[magicLen + cmdLen:], maxPayloadLen + 1)
+	_, _, err = readMessage(bytes.NewReader(data))
+	assert.Equal(t, errOversized, err)
+	assert.True(t, isMalformed(err))
+
+	//中途断开的连接是传输错误，不处罚对方
+	data = encode()
+	_, _, err = readMessage(bytes.NewReader(data[:len(data) - 1]))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.False(t, isMalformed(err))
+}
+
+func TestPayloadRoundTrip(t *testing.T) {
+	version := Version{nodeVersion, ServiceFullNode, 1234, 5, "localhost:3000", userAgent, 42}
+	var decoded Version
+	assert.Nil(t, decodePayload(encodePayload(&version), &decoded))
+	assert.Equal(t, version, decoded)
+
+	inv := Inventory{"localhost:3000", "block", [][]byte{{1, 2}, {3}}}
+	var decodedInv Inventory
+	assert.Nil(t, decodePayload(encodePayload(&inv), &decodedInv))
+	assert.Equal(t, inv, decodedInv)
+
+	getHeaders := GetHeaders{"", [][]byte{{1}}, []byte{2}}
+	var decodedGetHeaders GetHeaders
+	assert.Nil(t, decodePayload(encodePayload(&getHeaders), &decodedGetHeaders))
+	assert.Equal(t, getHeaders, decodedGetHeaders)
+
+	data := encodePayload(&inv)
+	assert.NotNil(t, decodePayload(data[:len(data) - 1], &decodedInv))
+	assert.NotNil(t, decodePayload(append(data, 0), &decodedInv))
+
+	//数量超过剩余数据时不分配列表
+	assert.NotNil(t, decodePayload([]byte{0, 0, 0xfe, 0xff, 0xff, 0xff, 0x7f}, &decodedInv))
+}
\ No newline at end of file
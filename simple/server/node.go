@@ -0,0 +1,294 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/block"
+	"github.com/pylrichard/building_block_chain_in_go/simple/config"
+	"github.com/pylrichard/building_block_chain_in_go/simple/mempool"
+	"github.com/pylrichard/building_block_chain_in_go/simple/utxo"
+)
+
+const maxMemPoolSize = 32 << 20
+//maxBlockTxsSize 一个区块中交易的总大小
+const maxBlockTxsSize = 1 << 20
+
+//Node 保存一个节点的全部状态，同一进程中可以运行多个Node
+type Node struct {
+	listenAddr		string
+	//addr 发送给其他节点的地址，也用于识别自己
+	addr			string
+	seeds			[]string
+	miningAddr		string
+	minMiningTxs	int
+	bc				*block.Chain
+
+	memPool		*mempool.Pool
+
+	//nonce 在version中发送，用于发现连接到了自己
+	nonce			uint64
+	addrMgr			*addrManager
+	//targetOutbound 连接管理器保持的出站连接数量
+	targetOutbound	int
+
+	//rpcAddr JSON-RPC服务的地址，rpcUser为空时使用rpcCookieFile中的cookie认证
+	rpcAddr			string
+	rpcUser			string
+	rpcPassword		string
+	rpcCookieFile	string
+	//explorerAddr 区块浏览器的地址，为空时不启动
+	explorerAddr	string
+
+	//chainLock 使区块的连接和mempool的更新按主链改变的顺序进行，chainstate和主链在同一个bolt事务中更新
+	chainLock	sync.Mutex
+
+	//syncLock 保护headers、inFlight和peerHeights
+	syncLock	sync.Mutex
+	headers		*block.HeaderChain
+	inFlight	map[string]*blockRequest
+	peerHeights	map[string]int
+
+	//peersLock 保护peers、conns和peersClosed
+	peersLock	sync.Mutex
+	peers		map[string]*peer
+	//conns 所有正在处理的连接，包括不知道监听地址和重复的连接，Shutdown时全部关闭
+	conns		map[*peer]bool
+	peersClosed	bool
+
+	banLock		sync.Mutex
+	banScores	map[string]int
+	bannedUntil	map[string]time.Time
+
+	miningLock		sync.Mutex
+	miningCancel	context.CancelFunc
+	isMining		bool
+	//miningClosed 节点关闭后不再开始新一轮挖矿
+	miningClosed	bool
+
+	//lock 保护listener和cancel
+	lock		sync.Mutex
+	listener	net.Listener
+	cancel		context.CancelFunc
+	wg			sync.WaitGroup
+}
+
+//NewNode 按cfg创建节点，cfg.Mining.Addr不为空时开启挖矿
+func NewNode(cfg *config.Config, bc *block.Chain) *Node {
+	addrMgr := newAddrManager(cfg.DataDir, cfg.NodeId, cfg.AdvertisedAddr())
+	err := addrMgr.Load()
+	if err != nil {
+		fmt.Printf("Failed to load peer addresses: %s\n", err)
+	}
+	addrMgr.Add(cfg.Seeds...)
+
+	return &Node{
+		listenAddr:		cfg.ListenAddr(),
+		addr:			cfg.AdvertisedAddr(),
+		seeds:			append([]string{}, cfg.Seeds...),
+		miningAddr:		cfg.Mining.Addr,
+		minMiningTxs:	cfg.Mining.MinTxs,
+		bc:				bc,
+		nonce:			randomNonce(),
+		addrMgr:		addrMgr,
+		targetOutbound:	cfg.OutboundPeers,
+		rpcAddr:		cfg.RPCAddr(),
+		rpcUser:		cfg.RPC.User,
+		rpcPassword:	cfg.RPC.Password,
+		rpcCookieFile:	cfg.RPCCookieFile(),
+		explorerAddr:	cfg.ExplorerAddr,
+		memPool:		mempool.NewPool(utxo.Set{Chain: bc}, maxMemPoolSize),
+		peers:			make(map[string]*peer),
+		conns:			make(map[*peer]bool),
+		banScores:		make(map[string]int),
+		bannedUntil:	make(map[string]time.Time),
+		headers:		bc.NewHeaderChain(),
+		inFlight:		make(map[string]*blockRequest),
+		peerHeights:	make(map[string]int),
+	}
+}
+
+//Run 开始监听并处理其他节点的连接、JSON-RPC请求和区块浏览器的请求，直到ctx被取消或调用Shutdown
+func (n *Node) Run(ctx context.Context) error {
+	l, err := net.Listen(protocol, n.listenAddr)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	n.lock.Lock()
+	n.listener = l
+	n.cancel = cancel
+	n.lock.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	err = n.startRPC(ctx)
+	if err != nil {
+		cancel()
+
+		return err
+	}
+
+	if n.explorerAddr != "" {
+		err = n.serveHTTP(ctx, "Explorer", n.explorerAddr, n.explorerHandler(), nil)
+		if err != nil {
+			cancel()
+
+			return err
+		}
+	}
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		n.syncLoop(ctx)
+	}()
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		n.connLoop(ctx)
+	}()
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		n.pingLoop(ctx)
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+
+			fmt.Printf("Failed to accept connection: %s\n", err)
+			time.Sleep(time.Second)
+
+			continue
+		}
+
+		if n.isBanned(remoteHost(conn)) {
+			conn.Close()
+
+			continue
+		}
+
+		n.serve(&peer{conn: conn})
+	}
+}
+
+//Shutdown 停止监听，断开所有连接并取消挖矿，等待所有goroutine退出
+func (n *Node) Shutdown() {
+	n.lock.Lock()
+	cancel := n.cancel
+	n.lock.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+
+	n.peersLock.Lock()
+	n.peersClosed = true
+	for p := range n.conns {
+		p.conn.Close()
+	}
+	n.peersLock.Unlock()
+
+	n.closeMining()
+	n.wg.Wait()
+
+	err := n.addrMgr.Save()
+	if err != nil {
+		fmt.Printf("Failed to save peer addresses: %s\n", err)
+	}
+}
+
+//serve 在单独的goroutine中处理连接，Shutdown会关闭连接并等待它退出
+func (n *Node) serve(p *peer) {
+	//在peersLock中调用wg.Add，Shutdown设置peersClosed之后不会再有新的goroutine
+	n.peersLock.Lock()
+	if n.peersClosed {
+		n.peersLock.Unlock()
+		p.conn.Close()
+
+		return
+	}
+	n.conns[p] = true
+	n.wg.Add(1)
+	n.peersLock.Unlock()
+
+	go func() {
+		defer n.wg.Done()
+		n.handleConnection(p)
+	}()
+}
+
+//startMining 标记开始挖矿，已经在挖矿时返回false
+func (n *Node) startMining() bool {
+	n.miningLock.Lock()
+	defer n.miningLock.Unlock()
+
+	if n.isMining || n.miningClosed {
+		return false
+	}
+	n.isMining = true
+
+	return true
+}
+
+//nextMiningRound 返回下一轮挖矿使用的ctx，收到新区块时通过cancelMining取消，节点关闭后返回false
+func (n *Node) nextMiningRound() (context.Context, bool) {
+	n.miningLock.Lock()
+	defer n.miningLock.Unlock()
+
+	if n.miningClosed {
+		return nil, false
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n.miningCancel = cancel
+
+	return ctx, true
+}
+
+func (n *Node) stopMining() {
+	n.miningLock.Lock()
+	defer n.miningLock.Unlock()
+
+	if n.miningCancel != nil {
+		n.miningCancel()
+	}
+	n.isMining = false
+}
+
+//cancelMining 取消当前一轮挖矿，挖矿goroutine在新的tip上重新开始
+func (n *Node) cancelMining() {
+	n.miningLock.Lock()
+	defer n.miningLock.Unlock()
+
+	if n.isMining && n.miningCancel != nil {
+		n.miningCancel()
+	}
+}
+
+//closeMining 取消挖矿并且不再开始新一轮挖矿
+func (n *Node) closeMining() {
+	n.miningLock.Lock()
+	defer n.miningLock.Unlock()
+
+	n.miningClosed = true
+	if n.isMining && n.miningCancel != nil {
+		n.miningCancel()
+	}
+}
\ No newline at end of file
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"github.com/pylrichard/building_block_chain_in_go/simple/codec"
+)
+
+//消息的payload使用和区块、交易相同的codec规范编码，整数为小端，字符串和字节串带varint长度前缀
+//列表为数量(varint) + 元素...，解析时检查数量不超过剩余数据，并且数据被完整读取
+
+//payload 可以编码为消息内容的类型
+type payload interface {
+	write(w *codec.Writer)
+	read(r *codec.Reader)
+}
+
+//encodePayload 返回p的编码
+func encodePayload(p payload) []byte {
+	w := codec.NewWriter()
+	p.write(w)
+
+	return w.Bytes()
+}
+
+//decodePayload 把其他节点发送的data解析到p，数据格式错误或有多余的数据时返回error
+func decodePayload(data []byte, p payload) error {
+	r := codec.NewReader(data)
+	p.read(r)
+
+	return r.Finish()
+}
+
+//Addr：地址数量(varint) + 地址(varbytes)...
+func (a *Addr) write(w *codec.Writer) {
+	writeStrings(w, a.AddrList)
+}
+
+func (a *Addr) read(r *codec.Reader) {
+	a.AddrList = readStrings(r)
+}
+
+//Block：AddrFrom(varbytes) + Block(varbytes)，区块的格式见block.Serialize
+func (b *Block) write(w *codec.Writer) {
+	w.WriteVarBytes([]byte(b.AddrFrom))
+	w.WriteVarBytes(b.Block)
+}
+
+func (b *Block) read(r *codec.Reader) {
+	b.AddrFrom = string(r.ReadVarBytes())
+	b.Block = r.ReadVarBytes()
+}
+
+//GetData：AddrFrom(varbytes) + Type(varbytes) + Id(varbytes)
+func (g *GetData) write(w *codec.Writer) {
+	w.WriteVarBytes([]byte(g.AddrFrom))
+	w.WriteVarBytes([]byte(g.Type))
+	w.WriteVarBytes(g.Id)
+}
+
+func (g *GetData) read(r *codec.Reader) {
+	g.AddrFrom = string(r.ReadVarBytes())
+	g.Type = string(r.ReadVarBytes())
+	g.Id = r.ReadVarBytes()
+}
+
+//Inventory：AddrFrom(varbytes) + Type(varbytes) + Items数量(varint) + Item(varbytes)...
+func (inv *Inventory) write(w *codec.Writer) {
+	w.WriteVarBytes([]byte(inv.AddrFrom))
+	w.WriteVarBytes([]byte(inv.Type))
+	writeByteList(w, inv.Items)
+}
+
+func (inv *Inventory) read(r *codec.Reader) {
+	inv.AddrFrom = string(r.ReadVarBytes())
+	inv.Type = string(r.ReadVarBytes())
+	inv.Items = readByteList(r)
+}
+
+//Tx：AddrFrom(varbytes) + Transaction(varbytes)，交易的格式见transaction.Serialize
+func (tx *Tx) write(w *codec.Writer) {
+	w.WriteVarBytes([]byte(tx.AddrFrom))
+	w.WriteVarBytes(tx.Transaction)
+}
+
+func (tx *Tx) read(r *codec.Reader) {
+	tx.AddrFrom = string(r.ReadVarBytes())
+	tx.Transaction = r.ReadVarBytes()
+}
+
+//Version：Version(4) + Services(8) + Timestamp(8) + BestHeight(4) + AddrFrom(varbytes) + UserAgent(varbytes) + Nonce(8)
+func (v *Version) write(w *codec.Writer) {
+	w.WriteUint32(uint32(v.Version))
+	w.WriteUint64(v.Services)
+	w.WriteInt64(v.Timestamp)
+	w.WriteUint32(uint32(v.BestHeight))
+	w.WriteVarBytes([]byte(v.AddrFrom))
+	w.WriteVarBytes([]byte(v.UserAgent))
+	w.WriteUint64(v.Nonce)
+}
+
+func (v *Version) read(r *codec.Reader) {
+	v.Version = int(r.ReadUint32())
+	v.Services = r.ReadUint64()
+	v.Timestamp = r.ReadInt64()
+	v.BestHeight = int(r.ReadUint32())
+	v.AddrFrom = string(r.ReadVarBytes())
+	v.UserAgent = string(r.ReadVarBytes())
+	v.Nonce = r.ReadUint64()
+}
+
+//Ping和Pong：Nonce(8)
+func (p *Ping) write(w *codec.Writer) {
+	w.WriteUint64(p.Nonce)
+}
+
+func (p *Ping) read(r *codec.Reader) {
+	p.Nonce = r.ReadUint64()
+}
+
+func (p *Pong) write(w *codec.Writer) {
+	w.WriteUint64(p.Nonce)
+}
+
+func (p *Pong) read(r *codec.Reader) {
+	p.Nonce = r.ReadUint64()
+}
+
+//GetHeaders：AddrFrom(varbytes) + Locator数量(varint) + 区块哈希(varbytes)... + StopHash(varbytes)
+func (g *GetHeaders) write(w *codec.Writer) {
+	w.WriteVarBytes([]byte(g.AddrFrom))
+	writeByteList(w, g.Locator)
+	w.WriteVarBytes(g.StopHash)
+}
+
+func (g *GetHeaders) read(r *codec.Reader) {
+	g.AddrFrom = string(r.ReadVarBytes())
+	g.Locator = readByteList(r)
+	g.StopHash = r.ReadVarBytes()
+}
+
+//Headers：AddrFrom(varbytes) + Headers数量(varint) + 区块头(varbytes)...，区块头的格式见BlockHeader.Serialize
+func (h *Headers) write(w *codec.Writer) {
+	w.WriteVarBytes([]byte(h.AddrFrom))
+	writeByteList(w, h.Headers)
+}
+
+func (h *Headers) read(r *codec.Reader) {
+	h.AddrFrom = string(r.ReadVarBytes())
+	h.Headers = readByteList(r)
+}
+
+func writeByteList(w *codec.Writer, list [][]byte) {
+	w.WriteVarInt(uint64(len(list)))
+	for _, item := range list {
+		w.WriteVarBytes(item)
+	}
+}
+
+//readByteList 读取字节串列表，每个元素至少占1个字节的长度前缀
+func readByteList(r *codec.Reader) [][]byte {
+	var list [][]byte
+
+	num := r.ReadCount(1)
+	for i := 0; i < num && r.Err() == nil; i++ {
+		list = append(list, r.ReadVarBytes())
+	}
+
+	return list
+}
+
+func writeStrings(w *codec.Writer, list []string) {
+	w.WriteVarInt(uint64(len(list)))
+	for _, s := range list {
+		w.WriteVarBytes([]byte(s))
+	}
+}
+
+func readStrings(r *codec.Reader) []string {
+	var list []string
+
+	for _, item := range readByteList(r) {
+		list = append(list, string(item))
+	}
+
+	return list
+}
\ No newline at end of file
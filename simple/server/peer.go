@@ -0,0 +1,184 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const dialTimeout = 10 * time.Second
+const writeTimeout = 30 * time.Second
+
+//peer 是与其他节点之间的长连接，双方在同一个连接上收发多条消息
+type peer struct {
+	conn		net.Conn
+	//addr 对方节点的监听地址，入站连接在收到version后才知道
+	addr		string
+	//outbound 连接是否由本节点发起
+	outbound	bool
+	writeLock	sync.Mutex
+	state		handshakeState
+}
+
+func (p *peer) send(cmd string, payload []byte) error {
+	p.writeLock.Lock()
+	defer p.writeLock.Unlock()
+
+	err := p.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err != nil {
+		return err
+	}
+
+	return writeMessage(p.conn, cmd, payload)
+}
+
+//getPeer 返回到addr的连接，没有连接时建立新连接并开始读取消息
+func (n *Node) getPeer(addr string) (*peer, error) {
+	n.peersLock.Lock()
+	p, ok := n.peers[addr]
+	n.peersLock.Unlock()
+	if ok {
+		return p, nil
+	}
+
+	if n.isBanned(addrHost(addr)) {
+		return nil, fmt.Errorf("%s is banned", addr)
+	}
+
+	n.addrMgr.Attempt(addr)
+	conn, err := net.DialTimeout(protocol, addr, dialTimeout)
+	if err != nil {
+		n.addrMgr.Failed(addr)
+
+		return nil, err
+	}
+	//addr为域名时只有连接后才知道对方的IP
+	if n.isBanned(remoteHost(conn)) {
+		conn.Close()
+
+		return nil, fmt.Errorf("%s is banned", addr)
+	}
+	n.addrMgr.Good(addr)
+
+	p = &peer{conn: conn, addr: addr, outbound: true}
+
+	n.peersLock.Lock()
+	if existing, ok := n.peers[addr]; ok {
+		n.peersLock.Unlock()
+		conn.Close()
+
+		return existing, nil
+	}
+	n.peers[addr] = p
+	n.peersLock.Unlock()
+
+	n.serve(p)
+
+	//发起连接的节点先发送version
+	err = n.sendVersion(p)
+	if err != nil {
+		n.removePeer(p)
+
+		return nil, err
+	}
+
+	return p, nil
+}
+
+//registerPeer 记录入站连接对应的监听地址，之后发往该地址的消息复用这个连接
+//已经有到addr的连接时返回false，调用者需要断开重复的连接
+func (n *Node) registerPeer(p *peer, addr string) bool {
+	if addr == "" {
+		return true
+	}
+
+	n.peersLock.Lock()
+	defer n.peersLock.Unlock()
+
+	if _, ok := n.peers[addr]; ok {
+		return false
+	}
+
+	p.addr = addr
+	n.peers[addr] = p
+
+	return true
+}
+
+func (n *Node) removePeer(p *peer) {
+	n.peersLock.Lock()
+	removed := n.peers[p.addr] == p
+	if removed {
+		delete(n.peers, p.addr)
+	}
+	delete(n.conns, p)
+	n.peersLock.Unlock()
+
+	p.conn.Close()
+
+	if removed {
+		n.peerDisconnected(p.addr)
+	}
+}
+
+func (n *Node) getPeers() []*peer {
+	n.peersLock.Lock()
+	defer n.peersLock.Unlock()
+
+	var peers []*peer
+	for _, p := range n.peers {
+		peers = append(peers, p)
+	}
+
+	return peers
+}
+
+//getConns 返回所有正在处理的连接，包括不知道监听地址的入站连接
+func (n *Node) getConns() []*peer {
+	n.peersLock.Lock()
+	defer n.peersLock.Unlock()
+
+	var peers []*peer
+	for p := range n.conns {
+		peers = append(peers, p)
+	}
+
+	return peers
+}
+
+//peerAddrs 返回已经知道监听地址的连接
+func (n *Node) peerAddrs() []string {
+	n.peersLock.Lock()
+	defer n.peersLock.Unlock()
+
+	var addrs []string
+	for addr := range n.peers {
+		addrs = append(addrs, addr)
+	}
+
+	return addrs
+}
+
+func (n *Node) isConnected(addr string) bool {
+	n.peersLock.Lock()
+	defer n.peersLock.Unlock()
+
+	_, ok := n.peers[addr]
+
+	return ok
+}
+
+func (n *Node) outboundCount() int {
+	n.peersLock.Lock()
+	defer n.peersLock.Unlock()
+
+	count := 0
+	for _, p := range n.peers {
+		if p.outbound {
+			count++
+		}
+	}
+
+	return count
+}
\ No newline at end of file
@@ -0,0 +1,314 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+//JSON-RPC 2.0服务，通过HTTP POST接收请求，支持批量请求和通知
+//配置了用户名和密码时使用Basic认证，否则启动时在数据目录中写入随机的cookie，客户端用cookie文件的内容认证
+
+const rpcVersion = "2.0"
+//rpcCookieUser 使用cookie认证时的用户名
+const rpcCookieUser = "__cookie__"
+const maxRPCRequestSize = 1 << 20
+//httpShutdownTimeout 关闭HTTP服务时等待请求完成的时间
+const httpShutdownTimeout = 5 * time.Second
+
+//JSON-RPC 2.0定义的错误码，以及本节点使用的错误码
+const (
+	RPCParseError		= -32700
+	RPCInvalidRequest	= -32600
+	RPCMethodNotFound	= -32601
+	RPCInvalidParams	= -32602
+	RPCInternalError	= -32603
+	//RPCNotFound 请求的区块、交易或地址不存在
+	RPCNotFound			= -5
+	//RPCTxRejected 交易没有被memPool接受
+	RPCTxRejected		= -26
+)
+
+//RPCError 是JSON-RPC响应中的error，客户端收到error时返回它
+type RPCError struct {
+	Code	int		`json:"code"`
+	Message	string	`json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+type rpcRequest struct {
+	JSONRPC	string				`json:"jsonrpc"`
+	Method	string				`json:"method"`
+	Params	json.RawMessage		`json:"params"`
+	//Id 为空的请求是通知，不需要响应
+	Id		json.RawMessage		`json:"id"`
+}
+
+type rpcResponse struct {
+	JSONRPC	string			`json:"jsonrpc"`
+	Result	json.RawMessage	`json:"result,omitempty"`
+	Error	*RPCError		`json:"error,omitempty"`
+	Id		json.RawMessage	`json:"id"`
+}
+
+//rpcHandler 处理一个方法，params为按位置排列的参数
+type rpcHandler func(n *Node, params []json.RawMessage) (interface{}, error)
+
+//startRPC 开始监听RPC地址，ctx被取消时关闭服务并删除cookie文件
+func (n *Node) startRPC(ctx context.Context) error {
+	user, password := n.rpcUser, n.rpcPassword
+	if user == "" {
+		cookie, err := writeRPCCookie(n.rpcCookieFile)
+		if err != nil {
+			return err
+		}
+		user, password = rpcCookieUser, cookie
+	}
+
+	removeCookie := func() {
+		if n.rpcUser == "" {
+			os.Remove(n.rpcCookieFile)
+		}
+	}
+
+	handler := n.rpcAuth(user, password, http.HandlerFunc(n.serveRPC))
+	err := n.serveHTTP(ctx, "JSON-RPC", n.rpcAddr, handler, removeCookie)
+	if err != nil {
+		removeCookie()
+	}
+
+	return err
+}
+
+//serveHTTP 在addr上提供handler，ctx被取消时关闭服务，然后调用onClose，Shutdown会等待它们退出
+func (n *Node) serveHTTP(ctx context.Context, name, addr string, handler http.Handler, onClose func()) error {
+	l, err := net.Listen(protocol, addr)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s server is listening on %s\n", name, l.Addr())
+
+	srv := &http.Server{Handler: handler}
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+
+		err := srv.Serve(l)
+		if err != nil && err != http.ErrServerClosed {
+			fmt.Printf("%s server stopped: %s\n", name, err)
+		}
+	}()
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+
+		if onClose != nil {
+			onClose()
+		}
+	}()
+
+	return nil
+}
+
+//rpcAuth 检查Basic认证，失败时返回401
+func (n *Node) rpcAuth(user, password string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		userMatch := subtle.ConstantTimeCompare([]byte(u), []byte(user)) == 1
+		passwordMatch := subtle.ConstantTimeCompare([]byte(p), []byte(password)) == 1
+		if !ok || !userMatch || !passwordMatch {
+			w.Header().Set("WWW-Authenticate", `Basic realm="jsonrpc"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+//serveRPC 处理单个请求或批量请求，批量请求的响应中不包括通知
+func (n *Node) serveRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRPCRequestSize))
+	if err != nil {
+		writeJSON(w, http.StatusOK, rpcErrorResponse(nil, &RPCError{RPCInvalidRequest, err.Error()}))
+
+		return
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '[' {
+		resp, ok := n.handleRPCRequest(body)
+		if !ok {
+			w.WriteHeader(http.StatusNoContent)
+
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+
+		return
+	}
+
+	var batch []json.RawMessage
+	err = json.Unmarshal(body, &batch)
+	if err != nil {
+		writeJSON(w, http.StatusOK, rpcErrorResponse(nil, &RPCError{RPCParseError, err.Error()}))
+
+		return
+	}
+	if len(batch) == 0 {
+		writeJSON(w, http.StatusOK, rpcErrorResponse(nil, &RPCError{RPCInvalidRequest, "empty batch"}))
+
+		return
+	}
+
+	resps := []rpcResponse{}
+	for _, raw := range batch {
+		if resp, ok := n.handleRPCRequest(raw); ok {
+			resps = append(resps, resp)
+		}
+	}
+	if len(resps) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+
+		return
+	}
+	writeJSON(w, http.StatusOK, resps)
+}
+
+//handleRPCRequest 执行一个请求，请求是通知时返回false
+func (n *Node) handleRPCRequest(raw []byte) (rpcResponse, bool) {
+	var req rpcRequest
+
+	err := json.Unmarshal(raw, &req)
+	if err != nil {
+		if _, ok := err.(*json.SyntaxError); ok {
+			return rpcErrorResponse(nil, &RPCError{RPCParseError, err.Error()}), true
+		}
+
+		return rpcErrorResponse(nil, &RPCError{RPCInvalidRequest, err.Error()}), true
+	}
+	if req.JSONRPC != rpcVersion || req.Method == "" {
+		return rpcErrorResponse(req.Id, &RPCError{RPCInvalidRequest, "invalid request"}), true
+	}
+
+	result, rpcErr := n.callRPC(req.Method, req.Params)
+	if len(req.Id) == 0 {
+		return rpcResponse{}, false
+	}
+	if rpcErr != nil {
+		return rpcErrorResponse(req.Id, rpcErr), true
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return rpcErrorResponse(req.Id, &RPCError{RPCInternalError, err.Error()}), true
+	}
+
+	return rpcResponse{JSONRPC: rpcVersion, Result: data, Id: req.Id}, true
+}
+
+//callRPC 找到method的处理函数并执行，handler返回的不是RPCError的error视为内部错误
+func (n *Node) callRPC(method string, rawParams json.RawMessage) (result interface{}, rpcErr *RPCError) {
+	handler, ok := rpcHandlers[method]
+	if !ok {
+		return nil, &RPCError{RPCMethodNotFound, fmt.Sprintf("method %q is not found", method)}
+	}
+
+	var params []json.RawMessage
+	rawParams = bytes.TrimSpace(rawParams)
+	if len(rawParams) > 0 && !bytes.Equal(rawParams, []byte("null")) {
+		err := json.Unmarshal(rawParams, &params)
+		if err != nil {
+			return nil, &RPCError{RPCInvalidParams, "params must be an array"}
+		}
+	}
+
+	//一个请求出错不影响节点
+	defer func() {
+		if r := recover(); r != nil {
+			result = nil
+			rpcErr = &RPCError{RPCInternalError, fmt.Sprint(r)}
+		}
+	}()
+
+	result, err := handler(n, params)
+	if err != nil {
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &RPCError{RPCInternalError, err.Error()}
+		}
+
+		return nil, rpcErr
+	}
+
+	return result, nil
+}
+
+//unmarshalParams 把按位置排列的参数依次解码到args，至少需要required个参数，缺少的可选参数保持原值
+func unmarshalParams(params []json.RawMessage, required int, args ...interface{}) error {
+	if len(params) < required || len(params) > len(args) {
+		return &RPCError{RPCInvalidParams, fmt.Sprintf("expected %d to %d params, got %d", required, len(args), len(params))}
+	}
+
+	for i, param := range params {
+		err := json.Unmarshal(param, args[i])
+		if err != nil {
+			return &RPCError{RPCInvalidParams, fmt.Sprintf("param %d: %s", i, err)}
+		}
+	}
+
+	return nil
+}
+
+func rpcErrorResponse(id json.RawMessage, rpcErr *RPCError) rpcResponse {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+
+	return rpcResponse{JSONRPC: rpcVersion, Error: rpcErr, Id: id}
+}
+
+//writeRPCCookie 生成随机的cookie，以user:password的格式写入file，只有所有者可以读取
+func writeRPCCookie(file string) (string, error) {
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	cookie := hex.EncodeToString(b)
+	err = ioutil.WriteFile(file, []byte(rpcCookieUser + ":" + cookie), 0600)
+	if err != nil {
+		return "", err
+	}
+
+	return cookie, nil
+}
\ No newline at end of file
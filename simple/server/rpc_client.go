@@ -0,0 +1,100 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/config"
+)
+
+const rpcClientTimeout = 30 * time.Second
+
+//RPCClient 通过JSON-RPC访问正在运行的节点，用于不能打开节点数据库的命令
+type RPCClient struct {
+	url			string
+	user		string
+	password	string
+	nextId		uint64
+	client		*http.Client
+}
+
+//NewRPCClient 按cfg连接节点的RPC服务，没有配置用户名和密码时从数据目录读取节点写入的cookie
+func NewRPCClient(cfg *config.Config) (*RPCClient, error) {
+	user, password := cfg.RPC.User, cfg.RPC.Password
+	if user == "" {
+		cookie, err := ioutil.ReadFile(cfg.RPCCookieFile())
+		if err != nil {
+			return nil, fmt.Errorf("can not read rpc cookie, is the node running? %s", err)
+		}
+
+		parts := strings.SplitN(strings.TrimSpace(string(cookie)), ":", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("rpc cookie file is malformed")
+		}
+		user, password = parts[0], parts[1]
+	}
+
+	return &RPCClient{
+		url:		"http://" + cfg.RPCAddr() + "/",
+		user:		user,
+		password:	password,
+		client:		&http.Client{Timeout: rpcClientTimeout},
+	}, nil
+}
+
+//Call 调用method，params按位置发送，result不为nil时把结果解码到result
+//节点返回的错误以*RPCError返回
+func (c *RPCClient) Call(method string, result interface{}, params ...interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+
+	id := atomic.AddUint64(&c.nextId, 1)
+	body, err := json.Marshal(map[string]interface{}{
+		"jsonrpc":	rpcVersion,
+		"method":	method,
+		"params":	params,
+		"id":		id,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(c.user, c.password)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("rpc server returned %s", resp.Status)
+	}
+
+	var rpcResp rpcResponse
+	err = json.NewDecoder(resp.Body).Decode(&rpcResp)
+	if err != nil {
+		return err
+	}
+	if rpcResp.Error != nil {
+		return rpcResp.Error
+	}
+	if result == nil {
+		return nil
+	}
+
+	return json.Unmarshal(rpcResp.Result, result)
+}
\ No newline at end of file
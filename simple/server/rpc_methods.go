@@ -0,0 +1,426 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/block"
+	"github.com/pylrichard/building_block_chain_in_go/simple/codec"
+	"github.com/pylrichard/building_block_chain_in_go/simple/transaction"
+	"github.com/pylrichard/building_block_chain_in_go/simple/utxo"
+	"github.com/pylrichard/building_block_chain_in_go/simple/wallet"
+)
+
+//哈希和交易Id以hex字符串表示，verbose为false时区块和交易以规范编码的hex返回
+
+var rpcHandlers = map[string]rpcHandler{
+	"getblockcount":		rpcGetBlockCount,
+	"getblockhash":			rpcGetBlockHash,
+	"getblock":				rpcGetBlock,
+	"gettransaction":		rpcGetTransaction,
+	"getmempool":			rpcGetMempool,
+	"sendrawtransaction":	rpcSendRawTransaction,
+	"getbalance":			rpcGetBalance,
+	"listunspent":			rpcListUnspent,
+	"getpeerinfo":			rpcGetPeerInfo,
+}
+
+type BlockResult struct {
+	Hash			string		`json:"hash"`
+	Height			int			`json:"height"`
+	PrevBlockHash	string		`json:"prev_block_hash"`
+	MerkleRoot		string		`json:"merkle_root"`
+	Timestamp		int64		`json:"timestamp"`
+	Bits			int			`json:"bits"`
+	Nonce			int			`json:"nonce"`
+	//Confirmations 区块不在主链上时为-1
+	Confirmations	int			`json:"confirmations"`
+	Transactions	[]TxResult	`json:"tx"`
+}
+
+type TxResult struct {
+	TxId			string				`json:"txid"`
+	CoinBase		bool				`json:"coinbase"`
+	Size			int					`json:"size"`
+	Inputs			[]TxInputResult		`json:"vin"`
+	Outputs			[]TxOutputResult	`json:"vout"`
+	//Height 交易所在区块的高度，交易在mempool中时为-1
+	Height			int					`json:"height"`
+	Confirmations	int					`json:"confirmations"`
+}
+
+type TxInputResult struct {
+	TxId		string	`json:"txid,omitempty"`
+	Out			int		`json:"vout"`
+	//Addr 花费的输出的地址，由PubKey计算得到
+	Addr		string	`json:"addr,omitempty"`
+	Signature	string	`json:"signature,omitempty"`
+	PubKey		string	`json:"pubkey,omitempty"`
+	//Data Coinbase交易的输入中保存的数据
+	Data		string	`json:"data,omitempty"`
+}
+
+type TxOutputResult struct {
+	Value	int		`json:"value"`
+	Addr	string	`json:"addr"`
+}
+
+type MempoolEntry struct {
+	TxId	string	`json:"txid"`
+	Size	int		`json:"size"`
+	Fee		int		`json:"fee"`
+}
+
+type UnspentResult struct {
+	TxId		string	`json:"txid"`
+	Out			int		`json:"vout"`
+	Value		int		`json:"value"`
+	Height		int		`json:"height"`
+	CoinBase	bool	`json:"coinbase"`
+	Spendable	bool	`json:"spendable"`
+}
+
+type PeerInfo struct {
+	Addr				string	`json:"addr"`
+	RemoteAddr			string	`json:"remote_addr"`
+	Inbound				bool	`json:"inbound"`
+	HandshakeComplete	bool	`json:"handshake_complete"`
+	Version				int		`json:"version"`
+	Services			uint64	`json:"services"`
+	UserAgent			string	`json:"user_agent"`
+	StartHeight			int		`json:"start_height"`
+	//SyncHeight 根据对方发送的区块头得知的高度
+	SyncHeight			int		`json:"sync_height"`
+	//LatencyMs 最近一次ping的往返时间，还没有收到pong时为0
+	LatencyMs			int64	`json:"latency_ms"`
+}
+
+func rpcGetBlockCount(n *Node, params []json.RawMessage) (interface{}, error) {
+	err := unmarshalParams(params, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return n.bc.GetBestHeight(), nil
+}
+
+func rpcGetBlockHash(n *Node, params []json.RawMessage) (interface{}, error) {
+	var height int
+
+	err := unmarshalParams(params, 1, &height)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := n.bc.GetBlockByHeight(height)
+	if err != nil {
+		return nil, &RPCError{RPCNotFound, fmt.Sprintf("block at height %d is not found", height)}
+	}
+
+	return hex.EncodeToString(b.Hash), nil
+}
+
+func rpcGetBlock(n *Node, params []json.RawMessage) (interface{}, error) {
+	var hash string
+	verbose := true
+
+	err := unmarshalParams(params, 1, &hash, &verbose)
+	if err != nil {
+		return nil, err
+	}
+
+	rawHash, err := decodeHashParam(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := n.bc.GetBlock(rawHash)
+	if err != nil {
+		return nil, &RPCError{RPCNotFound, fmt.Sprintf("block %s is not found", hash)}
+	}
+	if !verbose {
+		return hex.EncodeToString(b.Serialize()), nil
+	}
+
+	return n.newBlockResult(&b), nil
+}
+
+func rpcGetTransaction(n *Node, params []json.RawMessage) (interface{}, error) {
+	var txId string
+	verbose := true
+
+	err := unmarshalParams(params, 1, &txId, &verbose)
+	if err != nil {
+		return nil, err
+	}
+
+	rawTxId, err := decodeHashParam(txId)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, height, ok := n.findTransaction(rawTxId)
+	if !ok {
+		return nil, &RPCError{RPCNotFound, fmt.Sprintf("transaction %s is not found", txId)}
+	}
+	if !verbose {
+		return hex.EncodeToString(tx.Serialize()), nil
+	}
+
+	return n.newTxResultAt(&tx, height), nil
+}
+
+//rpcGetMempool 按费率从高到低返回mempool中的交易
+func rpcGetMempool(n *Node, params []json.RawMessage) (interface{}, error) {
+	err := unmarshalParams(params, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return n.mempoolEntries(), nil
+}
+
+//rpcSendRawTransaction 接受hex编码的交易，和从其他节点收到的交易一样加入mempool并转发，返回交易Id
+func rpcSendRawTransaction(n *Node, params []json.RawMessage) (interface{}, error) {
+	var rawTx string
+
+	err := unmarshalParams(params, 1, &rawTx)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := hex.DecodeString(rawTx)
+	if err != nil {
+		return nil, &RPCError{RPCInvalidParams, "transaction is not valid hex"}
+	}
+
+	tx, err := transaction.DecodeTransaction(data)
+	if err != nil {
+		return nil, &RPCError{RPCInvalidParams, fmt.Sprintf("transaction can not be decoded: %s", err)}
+	}
+
+	err = n.acceptTx(tx, "")
+	if err != nil {
+		return nil, &RPCError{RPCTxRejected, err.Error()}
+	}
+	fmt.Printf("Accepted transaction %x from JSON-RPC\n", tx.Id)
+
+	return hex.EncodeToString(tx.Id), nil
+}
+
+//rpcGetBalance 返回地址在UTXO Set中的余额，包括未成熟的Coinbase输出
+func rpcGetBalance(n *Node, params []json.RawMessage) (interface{}, error) {
+	var addr string
+
+	err := unmarshalParams(params, 1, &addr)
+	if err != nil {
+		return nil, err
+	}
+
+	pubKeyHash, err := decodeAddrParam(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	balance := 0
+	for _, out := range n.unspentResults(pubKeyHash) {
+		balance += out.Value
+	}
+
+	return balance, nil
+}
+
+func rpcListUnspent(n *Node, params []json.RawMessage) (interface{}, error) {
+	var addr string
+
+	err := unmarshalParams(params, 1, &addr)
+	if err != nil {
+		return nil, err
+	}
+
+	pubKeyHash, err := decodeAddrParam(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return n.unspentResults(pubKeyHash), nil
+}
+
+//rpcGetPeerInfo 返回已经知道监听地址的连接，按地址排列
+func rpcGetPeerInfo(n *Node, params []json.RawMessage) (interface{}, error) {
+	err := unmarshalParams(params, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := []PeerInfo{}
+	for _, p := range n.getPeers() {
+		p.state.lock.Lock()
+		info := PeerInfo{
+			Addr:				p.addr,
+			RemoteAddr:			p.conn.RemoteAddr().String(),
+			Inbound:			!p.outbound,
+			HandshakeComplete:	p.state.versionReceived && p.state.verackReceived,
+			Version:			p.state.version,
+			Services:			p.state.services,
+			UserAgent:			p.state.userAgent,
+			StartHeight:		p.state.startHeight,
+			LatencyMs:			p.state.latency.Milliseconds(),
+		}
+		p.state.lock.Unlock()
+
+		n.syncLock.Lock()
+		info.SyncHeight = n.peerHeights[p.addr]
+		n.syncLock.Unlock()
+
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Addr < infos[j].Addr
+	})
+
+	return infos, nil
+}
+
+func (n *Node) newBlockResult(b *block.Block) BlockResult {
+	result := BlockResult{
+		Hash:			hex.EncodeToString(b.Hash),
+		Height:			b.Height,
+		PrevBlockHash:	hex.EncodeToString(b.PrevBlockHash),
+		MerkleRoot:		hex.EncodeToString(b.MerkleRoot),
+		Timestamp:		b.Timestamp,
+		Bits:			b.Bits,
+		Nonce:			b.Nonce,
+		Confirmations:	-1,
+		Transactions:	[]TxResult{},
+	}
+
+	if height, ok := n.bc.GetMainChainHeight(b.Hash); ok {
+		result.Confirmations = n.bc.GetBestHeight() - height + 1
+	}
+
+	for _, tx := range b.Transactions {
+		txResult := newTxResult(tx)
+		txResult.Height = b.Height
+		txResult.Confirmations = result.Confirmations
+		result.Transactions = append(result.Transactions, txResult)
+	}
+
+	return result
+}
+
+//newTxResultAt 返回高度为height的交易的结果，height为-1表示交易在mempool中
+func (n *Node) newTxResultAt(tx *transaction.Transaction, height int) TxResult {
+	result := newTxResult(tx)
+	result.Height = height
+	if height >= 0 {
+		result.Confirmations = n.bc.GetBestHeight() - height + 1
+	}
+
+	return result
+}
+
+func newTxResult(tx *transaction.Transaction) TxResult {
+	result := TxResult{
+		TxId:		hex.EncodeToString(tx.Id),
+		CoinBase:	tx.IsCoinBase(),
+		Size:		len(tx.Serialize()),
+		Inputs:		[]TxInputResult{},
+		Outputs:	[]TxOutputResult{},
+	}
+
+	for _, in := range tx.In {
+		if result.CoinBase {
+			result.Inputs = append(result.Inputs, TxInputResult{Out: in.Out, Data: hex.EncodeToString(in.PubKey)})
+
+			continue
+		}
+
+		result.Inputs = append(result.Inputs, TxInputResult{
+			TxId:		hex.EncodeToString(in.TxId),
+			Out:		in.Out,
+			Addr:		string(wallet.PubKeyHashToAddr(wallet.HashPubKey(in.PubKey))),
+			Signature:	hex.EncodeToString(in.Signature),
+			PubKey:		hex.EncodeToString(in.PubKey),
+		})
+	}
+
+	for _, out := range tx.Out {
+		result.Outputs = append(result.Outputs, TxOutputResult{out.Value, string(wallet.PubKeyHashToAddr(out.PubKeyHash))})
+	}
+
+	return result
+}
+
+//findTransaction 先在mempool中查找交易，然后在主链上查找，没有启用交易索引时需要遍历主链
+//同时返回交易所在区块的高度，交易在mempool中时为-1
+func (n *Node) findTransaction(txId []byte) (transaction.Transaction, int, bool) {
+	if tx, ok := n.memPool.Get(txId); ok {
+		return tx, -1, true
+	}
+
+	tx, height, err := n.bc.FindMainChainTransaction(txId)
+	if err != nil {
+		return tx, 0, false
+	}
+
+	return tx, height, true
+}
+
+//mempoolEntries 按费率从高到低返回mempool中的交易
+func (n *Node) mempoolEntries() []MempoolEntry {
+	entries := []MempoolEntry{}
+
+	for _, tx := range n.memPool.Transactions() {
+		fee, ok := n.memPool.Fee(tx.Id)
+		if !ok {
+			continue
+		}
+
+		entries = append(entries, MempoolEntry{hex.EncodeToString(tx.Id), len(tx.Serialize()), fee})
+	}
+
+	return entries
+}
+
+//unspentResults 返回pubKeyHash在UTXO Set中的输出，包括未成熟的Coinbase输出
+func (n *Node) unspentResults(pubKeyHash []byte) []UnspentResult {
+	set := utxo.Set{Chain: n.bc}
+	unspent := []UnspentResult{}
+
+	for _, out := range set.FindUnspent(pubKeyHash) {
+		unspent = append(unspent, UnspentResult{
+			TxId:		hex.EncodeToString(out.TxId),
+			Out:		out.Index,
+			Value:		out.Value,
+			Height:		out.Height,
+			CoinBase:	out.CoinBase,
+			Spendable:	out.Spendable,
+		})
+	}
+
+	return unspent
+}
+
+func decodeHashParam(s string) ([]byte, error) {
+	hash, err := hex.DecodeString(s)
+	if err != nil || len(hash) == 0 {
+		return nil, &RPCError{RPCInvalidParams, fmt.Sprintf("%q is not a valid hash", s)}
+	}
+
+	return hash, nil
+}
+
+//decodeAddrParam 检查地址并返回它的公钥哈希
+func decodeAddrParam(addr string) ([]byte, error) {
+	if !wallet.ValidateAddr(addr) {
+		return nil, &RPCError{RPCInvalidParams, fmt.Sprintf("%q is not a valid address", addr)}
+	}
+
+	pubKeyHash := codec.Base58Decode([]byte(addr))
+
+	return pubKeyHash[1 : len(pubKeyHash) - 4], nil
+}
\ No newline at end of file
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/block"
+	"github.com/pylrichard/building_block_chain_in_go/simple/config"
+	"github.com/pylrichard/building_block_chain_in_go/simple/utxo"
+	"github.com/pylrichard/building_block_chain_in_go/simple/wallet"
+)
+
+func TestRPC(t *testing.T) {
+	cfg := config.Default()
+	cfg.DataDir = t.TempDir()
+	cfg.Seeds = nil
+	assert.Nil(t, cfg.Validate())
+
+	addr := string(wallet.NewWallet().GetAddr())
+	bc := block.NewChainWithGenesis(addr, cfg.DataDir, cfg.NodeId)
+	defer bc.Db.Close()
+	utxo.Set{Chain: bc}.Reindex()
+
+	n := NewNode(cfg, bc)
+	srv := httptest.NewServer(n.rpcAuth("user", "password", http.HandlerFunc(n.serveRPC)))
+	defer srv.Close()
+
+	cfg.RPC = config.RPCConfig{Addr: strings.TrimPrefix(srv.URL, "http://"), User: "user", Password: "wrong"}
+	client, err := NewRPCClient(cfg)
+	assert.Nil(t, err)
+	assert.NotNil(t, client.Call("getblockcount", nil))
+
+	cfg.RPC.Password = "password"
+	client, err = NewRPCClient(cfg)
+	assert.Nil(t, err)
+
+	var height int
+	assert.Nil(t, client.Call("getblockcount", &height))
+	assert.Equal(t, 0, height)
+
+	var hash string
+	assert.Nil(t, client.Call("getblockhash", &hash, 0))
+	genesis, err := bc.GetBlockByHeight(0)
+	assert.Nil(t, err)
+	assert.Equal(t, hex.EncodeToString(genesis.Hash), hash)
+
+	var b BlockResult
+	assert.Nil(t, client.Call("getblock", &b, hash))
+	assert.Equal(t, 1, b.Confirmations)
+	assert.Len(t, b.Transactions, 1)
+	assert.Equal(t, addr, b.Transactions[0].Outputs[0].Addr)
+
+	var balance int
+	assert.Nil(t, client.Call("getbalance", &balance, addr))
+	value, err := genesis.Transactions[0].OutputValue()
+	assert.Nil(t, err)
+	assert.Equal(t, value, balance)
+
+	//创世块的Coinbase输出不需要等待成熟
+	var unspent []UnspentResult
+	assert.Nil(t, client.Call("listunspent", &unspent, addr))
+	assert.Len(t, unspent, 1)
+	assert.True(t, unspent[0].Spendable)
+	assert.Equal(t, b.Transactions[0].TxId, unspent[0].TxId)
+
+	err = client.Call("getblockhash", nil, 1)
+	assert.Equal(t, RPCNotFound, err.(*RPCError).Code)
+	err = client.Call("getbalance", nil, "bad")
+	assert.Equal(t, RPCInvalidParams, err.(*RPCError).Code)
+	err = client.Call("stop", nil)
+	assert.Equal(t, RPCMethodNotFound, err.(*RPCError).Code)
+}
+
+func TestRPCBatch(t *testing.T) {
+	n := newTestNode(t)
+	handler := http.HandlerFunc(n.serveRPC)
+
+	body := `[{"jsonrpc": "2.0", "method": "getblockcount", "id": 1},
+		{"jsonrpc": "2.0", "method": "getblockcount"},
+		{"jsonrpc": "2.0", "method": "getblockhash", "params": ["x"], "id": 2},
+		{"method": "getblockcount", "id": 3}]`
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[{"jsonrpc": "2.0", "result": 0, "id": 1},
+		{"jsonrpc": "2.0", "error": {"code": -32602, "message": "param 0: json: cannot unmarshal string into Go value of type int"}, "id": 2},
+		{"jsonrpc": "2.0", "error": {"code": -32600, "message": "invalid request"}, "id": 3}]`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"jsonrpc": "2.0", "method"`)))
+	assert.Contains(t, rec.Body.String(), `"code":-32700`)
+}
\ No newline at end of file
@@ -1,30 +1,24 @@
 package server
 
 import (
-	"bytes"
-	"encoding/gob"
-	"encoding/hex"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
 	"net"
+	"os"
+	"time"
 
 	"github.com/pylrichard/building_block_chain_in_go/simple/block"
+	"github.com/pylrichard/building_block_chain_in_go/simple/mempool"
 	"github.com/pylrichard/building_block_chain_in_go/simple/transaction"
-	"github.com/pylrichard/building_block_chain_in_go/simple/utxo"
 )
 
 const protocol = "tcp"
-const nodeVersion = 1
+//nodeVersion 本节点的协议版本，版本3开始payload使用codec编码
+const nodeVersion = 3
 const cmdLen = 12
 
-var nodeAddr string
-var miningAddr string
-var knownNodes = []string{"localHost:3000"}
-var blocksInTransit [][]byte
-var memPool = make(map[string]transaction.Transaction)
-
 type Addr struct {
 	AddrList []string
 }
@@ -34,10 +28,6 @@ type Block struct {
 	Block		[]byte
 }
 
-type GetBlocks struct {
-	AddrFrom string
-}
-
 type GetData struct {
 	AddrFrom	string
 	Type		string
@@ -57,408 +47,534 @@ type Tx struct {
 
 type Version struct {
 	Version		int
+	//Services 节点提供的服务，见ServiceFullNode等
+	Services	uint64
+	Timestamp	int64
 	BestHeight	int
+	//AddrFrom 节点的监听地址，不接受连接的客户端为空
 	AddrFrom	string
+	UserAgent	string
+	//Nonce 节点启动时随机生成，收到和自己相同的Nonce说明连接到了自己
+	Nonce		uint64
 }
 
-func StartServer(nodeId, minerAddr string) {
-	nodeAddr = fmt.Sprintf("localHost: %s", nodeId)
-	miningAddr = minerAddr
-
-	l, err := net.Listen(protocol, nodeAddr)
-	if err != nil {
-		log.Panic(err)
-	}
-	defer l.Close()
-
-	bc := block.NewChain(nodeId)
-
-	if nodeAddr != knownNodes[0] {
-		sendVersion(knownNodes[0], bc)
-	}
-
-	for {
-		conn, err := l.Accept()
-		if err != nil {
-			log.Panic(err)
-		}
-		go handleConnection(conn, bc)
-	}
+func (n *Node) sendAddr(addr string) {
+	nodes := Addr{n.addrMgr.ForRelay(maxAddrRelay)}
+	nodes.AddrList = append(nodes.AddrList, n.addr)
+	payload := encodePayload(&nodes)
+	n.sendData(addr, "addr", payload)
 }
 
-func cmdToBytes(cmd string) []byte {
-	var bytes [cmdLen]byte
-
-	for i, c := range cmd {
-		bytes[i] = byte(c)
-	}
-
-	return bytes[:]
+func (n *Node) sendBlock(p *peer, b *block.Block) {
+	data := Block{n.addr, b.Serialize()}
+	payload := encodePayload(&data)
+	n.sendTo(p, "block", payload)
 }
 
-func bytesToCmd(bytes []byte) string {
-	var cmd []byte
+//sendData 把消息发送给addr，没有到addr的连接时建立连接
+func (n *Node) sendData(addr, cmd string, payload []byte) {
+	p, err := n.getPeer(addr)
+	if err != nil {
+		fmt.Printf("%s is not available\n", addr)
 
-	for _, b := range bytes {
-		if b != 0x0 {
-			cmd = append(cmd, b)
-		}
+		return
 	}
 
-	return fmt.Sprintf("%s", cmd)
+	n.sendTo(p, cmd, payload)
 }
 
-func requestBlocks() {
-	for _, node := range knownNodes {
-		sendGetBlocks(node)
+//sendTo 在已有的连接上发送消息，回复请求时使用收到请求的连接，不连接对方声明的地址
+func (n *Node) sendTo(p *peer, cmd string, payload []byte) {
+	err := p.send(cmd, payload)
+	if err != nil {
+		fmt.Printf("Failed to send %s to %s: %s\n", cmd, p.conn.RemoteAddr(), err)
+		n.removePeer(p)
 	}
 }
 
-func sendAddr(addr string) {
-	nodes := Addr{knownNodes}
-	nodes.AddrList = append(nodes.AddrList, nodeAddr)
-	payload := gobEncode(nodes)
-	request := append(cmdToBytes("addr"), payload...)
-
-	sendData(addr, request)
+func (n *Node) sendInventory(addr, kind string, items [][]byte) {
+	inventory := Inventory{n.addr, kind, items}
+	payload := encodePayload(&inventory)
+	n.sendData(addr, "inventory", payload)
 }
 
-func sendBlock(addr string, b *block.Block) {
-	data := Block{nodeAddr, b.Serialize()}
-	payload := gobEncode(data)
-	request := append(cmdToBytes("block"), payload...)
-
-	sendData(addr, request)
+func (n *Node) sendGetData(addr, kind string, id []byte) {
+	payload := encodePayload(&GetData{n.addr, kind, id})
+	n.sendData(addr, "get_data", payload)
 }
 
-func sendData(addr string, data []byte) {
-	conn, err := net.Dial(protocol, addr)
+//SendTx 通过一次性连接把交易发送给addr对应的节点，用于没有启动节点的客户端
+//节点只处理发送了version的连接，在收到对方的verack之后才发送tx
+func SendTx(addr string, tx *transaction.Transaction) error {
+	conn, err := net.DialTimeout(protocol, addr, dialTimeout)
 	if err != nil {
-		fmt.Printf("%s is not available\n", addr)
-		var updateNodes []string
-
-		for _, node := range knownNodes {
-			if node != addr {
-				updateNodes = append(updateNodes, node)
-			}
-		}
-
-		knownNodes = updateNodes
-
-		return
+		return fmt.Errorf("%s is not available: %w", addr, err)
 	}
 	defer conn.Close()
 
-	_, err = io.Copy(conn, bytes.NewReader(data))
+	err = conn.SetDeadline(time.Now().Add(handshakeTimeout))
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
-}
-
-func sendInventory(addr, kind string, items [][]byte) {
-	inventory := Inventory{nodeAddr, kind, items}
-	payload := gobEncode(inventory)
-	request := append(cmdToBytes("inventory"), payload...)
 
-	sendData(addr, request)
-}
-
-func sendGetBlocks(addr string) {
-	payload := gobEncode(GetBlocks{nodeAddr})
-	request := append(cmdToBytes("get_blocks"), payload...)
+	version := Version{nodeVersion, 0, time.Now().Unix(), 0, "", userAgent, randomNonce()}
+	err = writeMessage(conn, "version", encodePayload(&version))
+	if err != nil {
+		return err
+	}
 
-	sendData(addr, request)
-}
+	err = waitVerack(conn)
+	if err != nil {
+		return fmt.Errorf("handshake with %s failed: %w", addr, err)
+	}
 
-func sendGetData(addr, kind string, id []byte) {
-	payload := gobEncode(GetData{nodeAddr, kind, id})
-	request := append(cmdToBytes("get_data"), payload...)
+	err = writeMessage(conn, "verack", nil)
+	if err != nil {
+		return err
+	}
 
-	sendData(addr, request)
+	return writeMessage(conn, "tx", encodePayload(&Tx{"", tx.Serialize()}))
 }
 
-func sendTx(addr string, tx *transaction.Transaction) {
-	data := Tx{nodeAddr, tx.Serialize()}
-	payload := gobEncode(data)
-	request := append(cmdToBytes("tx"), payload...)
-
-	sendData(addr, request)
+//waitVerack 读取对方回复的消息直到收到verack，对方先发送的version被忽略
+func waitVerack(conn net.Conn) error {
+	for {
+		cmd, _, err := readMessage(conn)
+		if err != nil {
+			return err
+		}
+		if cmd == "verack" {
+			return nil
+		}
+	}
 }
 
-func sendVersion(addr string, bc *block.Chain) {
-	bestHeight := bc.GetBestHeight()
-	payload := gobEncode(Version{nodeVersion, bestHeight, nodeAddr})
-	request := append(cmdToBytes("version"), payload...)
-
-	sendData(addr, request)
+func (n *Node) sendTx(p *peer, tx *transaction.Transaction) {
+	data := Tx{n.addr, tx.Serialize()}
+	payload := encodePayload(&data)
+	n.sendTo(p, "tx", payload)
 }
 
-func handleAddr(request []byte) {
-	var buff bytes.Buffer
+func (n *Node) handleAddr(request []byte) error {
 	var payload Addr
 
-	buff.Write(request[cmdLen:])
-	decoder := gob.NewDecoder(&buff)
-	err := decoder.Decode(&payload)
+	err := decodePayload(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return misbehaving(scoreMalformedMessage, err)
 	}
 
-	knownNodes = append(knownNodes, payload.AddrList...)
-	fmt.Printf("There are %d known nodes now!\n", len(knownNodes))
+	if len(payload.AddrList) > maxAddrPerMsg {
+		return misbehaving(scoreMalformedMessage, fmt.Errorf("%d addresses exceed the limit", len(payload.AddrList)))
+	}
+
+	added := n.addrMgr.Add(payload.AddrList...)
+	fmt.Printf("Added %d addresses, there are %d known nodes now!\n", added, n.addrMgr.Count())
 
-	requestBlocks()
+	return nil
 }
 
-func handleBlock(request []byte, bc *block.Chain) {
-	var buff bytes.Buffer
+func (n *Node) handleBlock(request []byte) error {
 	var payload Block
 
-	buff.Write(request[cmdLen:])
-	decoder := gob.NewDecoder(&buff)
-	err := decoder.Decode(&payload)
+	err := decodePayload(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return misbehaving(scoreMalformedMessage, err)
 	}
 
 	data := payload.Block
-	b := block.DeserializeBlock(data)
+	b, err := block.DecodeBlock(data)
+	if err != nil {
+		return misbehaving(scoreMalformedMessage, err)
+	}
 	fmt.Println("Received a new block!")
-	bc.AddBlock(b)
 
-	fmt.Printf("Added block %x\n", b.Hash)
+	update, err := n.addBlock(b)
+	switch {
+	case errors.Is(err, block.ErrTimeTooNew):
+		//可能只是两个节点的时钟不一致
+		fmt.Printf("Rejected block %x: %s\n", b.Hash, err)
 
-	if len(blocksInTransit) > 0 {
-		blockHash := blocksInTransit[0]
-		sendGetData(payload.AddrFrom, "block", blockHash)
+		return nil
+	case err != nil && !errors.Is(err, block.ErrBlockExists):
+		return misbehaving(scoreInvalidBlock, fmt.Errorf("invalid block %x: %s", b.Hash, err))
+	}
+	if err == nil {
+		fmt.Printf("Added block %x\n", b.Hash)
+	}
+	if len(update.Disconnected) > 0 {
+		fmt.Printf("Reorganized chain, %d blocks disconnected\n", len(update.Disconnected))
+	}
 
-		blocksInTransit = blocksInTransit[1:]
-	} else {
-		set := utxo.Set{Chain: bc}
-		set.Reindex()
+	if len(update.Connected) > 0 {
+		//其他节点先挖出了区块，当前挖矿的tip已经过期
+		n.cancelMining()
 	}
+
+	n.blockReceived(b.Hash)
+
+	return nil
 }
 
-func handleInventory(request []byte) {
-	var buff bytes.Buffer
+//addBlock 保存区块并按主链的变化更新mempool
+func (n *Node) addBlock(b *block.Block) (*block.ChainUpdate, error) {
+	n.chainLock.Lock()
+	defer n.chainLock.Unlock()
+
+	update, err := n.bc.AddBlock(b)
+	n.memPool.ApplyChainUpdate(update)
+
+	return update, err
+}
+
+func (n *Node) handleInventory(p *peer, request []byte) error {
 	var payload Inventory
 
-	buff.Write(request[cmdLen:])
-	decoder := gob.NewDecoder(&buff)
-	err := decoder.Decode(&payload)
+	err := decodePayload(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return misbehaving(scoreMalformedMessage, err)
 	}
 
 	fmt.Printf("Received inventory with %d %s\n", len(payload.Items), payload.Type)
+	if len(payload.Items) == 0 {
+		return misbehaving(scoreMalformedMessage, errors.New("inventory is empty"))
+	}
 
 	if payload.Type == "block" {
-		blocksInTransit = payload.Items
+		//先下载区块头找到分叉点，区块体在区块头验证后下载
+		for _, blockHash := range payload.Items {
+			if !n.bc.HasBlock(blockHash) && !n.hasHeader(blockHash) {
+				n.sendGetHeaders(p)
 
-		blockHash := payload.Items[0]
-		sendGetData(payload.AddrFrom, "block", blockHash)
-
-		var newInTransit [][]byte
-		for _, b := range blocksInTransit {
-			if bytes.Compare(b, blockHash) != 0 {
-				newInTransit = append(newInTransit, b)
+				break
 			}
 		}
-
-		blocksInTransit = newInTransit
 	}
 
 	if payload.Type == "tx" {
 		txId := payload.Items[0]
 
-		if memPool[hex.EncodeToString(txId)].Id == nil {
-			sendGetData(payload.AddrFrom, "tx", txId)
+		if !n.memPool.Has(txId) {
+			n.sendTo(p, "get_data", encodePayload(&GetData{n.addr, "tx", txId}))
 		}
 	}
-}
 
-func handleGetBlocks(request []byte, bc *block.Chain) {
-	var buff bytes.Buffer
-	var payload GetBlocks
-
-	buff.Write(request[cmdLen:])
-	decoder := gob.NewDecoder(&buff)
-	err := decoder.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	blocks := bc.GetBlockHashes()
-	sendInventory(payload.AddrFrom, "block", blocks)
+	return nil
 }
 
-func handleGetData(request []byte, bc *block.Chain) {
-	var buff bytes.Buffer
+func (n *Node) handleGetData(p *peer, request []byte) error {
 	var payload GetData
 
-	buff.Write(request[cmdLen:])
-	decoder := gob.NewDecoder(&buff)
-	err := decoder.Decode(&payload)
+	err := decodePayload(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return misbehaving(scoreMalformedMessage, err)
 	}
 
 	if payload.Type == "block" {
-		b, err := bc.GetBlock([]byte(payload.Id))
+		b, err := n.bc.GetBlock([]byte(payload.Id))
 		if err != nil {
-			return
+			return nil
 		}
 
-		sendBlock(payload.AddrFrom, &b)
+		n.sendBlock(p, &b)
 	}
 
 	if payload.Type == "tx" {
-		txId := hex.EncodeToString(payload.Id)
-		tx := memPool[txId]
+		tx, ok := n.memPool.Get(payload.Id)
+		if !ok {
+			return nil
+		}
 
-		sendTx(payload.AddrFrom, &tx)
+		n.sendTx(p, &tx)
 	}
+
+	return nil
 }
 
-func handleTx(request []byte, bc *block.Chain) {
-	var buff bytes.Buffer
+func (n *Node) handleTx(request []byte) error {
 	var payload Tx
 
-	buff.Write(request[cmdLen:])
-	decoder := gob.NewDecoder(&buff)
-	err := decoder.Decode(&payload)
+	err := decodePayload(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return misbehaving(scoreMalformedMessage, err)
 	}
 
 	txData := payload.Transaction
-	tx := transaction.DeserializeTransaction(txData)
-	memPool[hex.EncodeToString(tx.Id)] = tx
+	tx, err := transaction.DecodeTransaction(txData)
+	if err != nil {
+		return misbehaving(scoreMalformedMessage, err)
+	}
+
+	err = n.acceptTx(tx, payload.AddrFrom)
+	switch {
+	case err == mempool.ErrAlreadyExists:
+		return nil
+	case err == block.ErrInvalidTransaction || err == block.ErrInvalidValue || err == transaction.ErrValueOutOfRange ||
+		err == mempool.ErrNegativeFee || err == mempool.ErrCoinBase:
+		return misbehaving(scoreInvalidTx, fmt.Errorf("invalid transaction %x: %s", tx.Id, err))
+	case err != nil:
+		//输入缺失或冲突可能只是两个节点看到的主链不同
+		fmt.Printf("Rejected transaction %x: %s\n", tx.Id, err)
+
+		return nil
+	}
+
+	return nil
+}
+
+//acceptTx 把tx加入memPool，不挖矿的节点把它转发给from以外的节点，挖矿节点在交易足够时开始挖矿
+func (n *Node) acceptTx(tx transaction.Transaction, from string) error {
+	//交易按当前主链验证，验证期间主链不能改变，否则可能留下和新区块冲突的交易
+	memPoolSize, err := func() (int, error) {
+		n.chainLock.Lock()
+		defer n.chainLock.Unlock()
+
+		err := n.memPool.Add(tx)
+
+		return n.memPool.Count(), err
+	}()
+	if err != nil {
+		return err
+	}
 
-	if nodeAddr == knownNodes[0] {
-		for _, node := range knownNodes {
-			if node != nodeAddr && node != payload.AddrFrom {
-				sendInventory(node, "tx", [][]byte{tx.Id})
+	//不挖矿的节点转发交易，挖矿节点收集交易后打包
+	if len(n.miningAddr) == 0 {
+		for _, node := range n.peerAddrs() {
+			if node != from {
+				n.sendInventory(node, "tx", [][]byte{tx.Id})
 			}
 		}
 	} else {
-		if len(memPool) >= 2 && len(miningAddr) > 0 {
-		MineTransactions:
-			var txs []*transaction.Transaction
-
-			for id := range memPool {
-				tx := memPool[id]
-				if bc.VerifyTransaction(&tx) {
-					txs = append(txs, &tx)
-				}
-			}
+		if memPoolSize >= n.minMiningTxs {
+			//挖矿在单独的goroutine中进行，连接可以继续接收其他节点的区块
+			n.wg.Add(1)
+			go func() {
+				defer n.wg.Done()
+				n.mineTransactions()
+			}()
+		}
+	}
 
-			if len(txs) == 0 {
-				fmt.Println("All transactions are invalid! Waiting for new one...")
+	return nil
+}
 
-				return
-			}
+//mineTransactions 把memPool中的交易打包挖矿，同一时间只有一个goroutine挖矿
+func (n *Node) mineTransactions() {
+	if !n.startMining() {
+		return
+	}
+	defer n.stopMining()
 
-			cbTx := transaction.NewCoinBaseTx(miningAddr, "")
-			txs = append(txs, cbTx)
-			newBlock := bc.MineBlock(txs)
-			set := utxo.Set{Chain: bc}
-			set.Reindex()
+	for {
+		ctx, ok := n.nextMiningRound()
+		if !ok {
+			return
+		}
 
-			fmt.Println("New block is mined!")
+		//mempool中的交易在进入时已经验证过，按费率从高到低选择
+		txs, fees := n.memPool.BlockTemplate(maxBlockTxsSize)
 
-			for _, tx := range txs {
-				txId := hex.EncodeToString(tx.Id)
-				delete(memPool, txId)
-			}
+		if len(txs) == 0 {
+			fmt.Println("No transactions to mine! Waiting for new one...")
 
-			for _, node := range knownNodes {
-				if node != nodeAddr {
-					sendInventory(node, "block", [][]byte{newBlock.Hash})
-				}
-			}
+			return
+		}
 
-			if len(memPool) > 0 {
-				goto MineTransactions
-			}
+		cbTx := transaction.NewCoinBaseTx(n.miningAddr, "", n.bc.GetBestHeight() + 1, fees)
+		txs = append([]*transaction.Transaction{cbTx}, txs...)
+
+		newBlock, err := n.bc.MineBlockContext(ctx, txs)
+		//其他节点的区块改变了tip，在新的tip上重新选择交易挖矿
+		if errors.Is(err, context.Canceled) || errors.Is(err, block.ErrStaleTip) {
+			fmt.Println("Chain tip changed, restart mining on the new tip")
+
+			continue
+		}
+		if err != nil {
+			fmt.Printf("Mining is stopped: %s\n", err)
+
+			return
+		}
+
+		fmt.Println("New block is mined!")
+
+		//区块在挖出后可能已经被其他节点的分支断开，这时它的交易仍留在mempool中
+		n.chainLock.Lock()
+		if _, ok := n.bc.GetMainChainHeight(newBlock.Hash); ok {
+			n.memPool.RemoveForBlock(newBlock)
+		}
+		memPoolSize := n.memPool.Count()
+		n.chainLock.Unlock()
+
+		for _, node := range n.peerAddrs() {
+			n.sendInventory(node, "block", [][]byte{newBlock.Hash})
+		}
+
+		if memPoolSize == 0 {
+			return
 		}
 	}
 }
 
-func handleVersion(request []byte, bc *block.Chain) {
-	var buff bytes.Buffer
+//handleVersion 记录对方的版本和服务，入站连接回复version，然后回复verack
+func (n *Node) handleVersion(p *peer, request []byte) error {
 	var payload Version
 
-	buff.Write(request[cmdLen:])
-	decoder := gob.NewDecoder(&buff)
-	err := decoder.Decode(&payload)
+	err := decodePayload(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return misbehaving(scoreMalformedMessage, err)
 	}
 
-	h1 := bc.GetBestHeight()
-	h2 := payload.BestHeight
+	if payload.Nonce == n.nonce {
+		n.dropSelfConnection(p)
 
-	if h1 < h2 {
-		sendGetBlocks(payload.AddrFrom)
-	} else if h1 > h2 {
-		sendVersion(payload.AddrFrom, bc)
+		return errSelfConnection
+	}
+	if payload.Version < minProtocolVersion {
+		return fmt.Errorf("%w: %d", errObsoleteVersion, payload.Version)
+	}
+	if n.isBanned(banKey(p)) {
+		return misbehaving(banThreshold, fmt.Errorf("%s is banned", banKey(p)))
 	}
 
-	sendAddr(payload.AddrFrom)
-	if !isNodeKnown(payload.AddrFrom) {
-		knownNodes = append(knownNodes, payload.AddrFrom)
+	p.state.lock.Lock()
+	duplicate := p.state.versionReceived
+	if !duplicate {
+		p.state.versionReceived = true
+		p.state.version = payload.Version
+		if p.state.version > nodeVersion {
+			p.state.version = nodeVersion
+		}
+		p.state.services = payload.Services
+		p.state.userAgent = payload.UserAgent
+		p.state.startHeight = payload.BestHeight
+	}
+	p.state.lock.Unlock()
+	if duplicate {
+		return misbehaving(scoreProtocolViolation, errors.New("duplicate version"))
 	}
-}
 
-func handleConnection(conn net.Conn, bc *block.Chain) {
-	request, err := ioutil.ReadAll(conn)
+	err = p.conn.SetReadDeadline(time.Time{})
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
-	cmd := bytesToCmd(request[:cmdLen])
-	fmt.Printf("Recieved %s command\n", cmd)
 
-	switch cmd {
-	case "addr":
-		handleAddr(request)
-	case "block":
-		handleBlock(request, bc)
-	case "inventory":
-		handleInventory(request)
-	case "get_blocks":
-		handleGetBlocks(request, bc)
-	case "get_data":
-		handleGetData(request, bc)
-	case "tx":
-		handleTx(request, bc)
-	case "version":
-		handleVersion(request, bc)
-	default:
-		fmt.Println("Unknown command!")
+	//出站连接使用连接时的地址，入站连接使用对方声明的地址
+	addr := p.addr
+	if !p.outbound {
+		if !n.registerPeer(p, payload.AddrFrom) {
+			return fmt.Errorf("%w: %s", errDuplicateConnection, payload.AddrFrom)
+		}
+		addr = payload.AddrFrom
+
+		err = n.sendVersion(p)
+		if err != nil {
+			return err
+		}
 	}
 
-	conn.Close()
+	err = p.send("verack", nil)
+	if err != nil {
+		return err
+	}
+
+	if addr == "" {
+		return nil
+	}
+
+	if payload.Services & ServiceFullNode != 0 {
+		n.setPeerHeight(addr, payload.BestHeight)
+	}
+	n.addrMgr.Add(addr)
+	n.sendAddr(addr)
+
+	if n.bc.GetBestHeight() < payload.BestHeight {
+		n.sendGetHeaders(p)
+	}
+
+	return nil
 }
 
-func gobEncode(data interface{}) []byte {
-	var buff bytes.Buffer
+//handleConnection 在长连接上循环读取消息，连接关闭、消息格式错误、握手失败或对方被禁止时断开
+func (n *Node) handleConnection(p *peer) {
+	defer n.removePeer(p)
 
-	encoder := gob.NewEncoder(&buff)
-	err := encoder.Encode(data)
+	//在handshakeTimeout内没有收到version时断开
+	err := p.conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
 	if err != nil {
-		log.Panic(err)
+		return
 	}
 
-	return buff.Bytes()
+	for {
+		cmd, request, err := readMessage(p.conn)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			fmt.Printf("%s did not send version in time\n", p.conn.RemoteAddr())
+
+			return
+		}
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				fmt.Printf("Closing connection to %s: %s\n", p.conn.RemoteAddr(), err)
+			}
+			//连接重置或中途断开不是对方的过错，只处罚不符合协议的消息
+			if isMalformed(err) {
+				n.punishPeer(p, misbehaving(scoreMalformedMessage, err))
+			}
+
+			return
+		}
+		fmt.Printf("Received %s command\n", cmd)
+
+		err = n.handleMessage(p, cmd, request)
+		if err != nil {
+			fmt.Printf("Error handling %s from %s: %s\n", cmd, p.conn.RemoteAddr(), err)
+
+			var pe *peerError
+			if !errors.As(err, &pe) || n.punishPeer(p, err) {
+				return
+			}
+		}
+	}
 }
 
-func isNodeKnown(addr string) bool {
-	for _, node := range knownNodes {
-		if node == addr {
-			return true
+//handleMessage 分发消息，handler中的panic转换为对方节点的错误，不会影响整个节点
+func (n *Node) handleMessage(p *peer, cmd string, request []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = misbehaving(scoreHandlerPanic, fmt.Errorf("panic: %v", r))
 		}
+	}()
+
+	p.state.lock.Lock()
+	versionReceived := p.state.versionReceived
+	p.state.lock.Unlock()
+	if !versionReceived && cmd != "version" {
+		return misbehaving(scoreProtocolViolation, fmt.Errorf("%s before version", cmd))
 	}
 
-	return false
+	switch cmd {
+	case "addr":
+		return n.handleAddr(request)
+	case "block":
+		return n.handleBlock(request)
+	case "inventory":
+		return n.handleInventory(p, request)
+	case "get_data":
+		return n.handleGetData(p, request)
+	case "get_headers":
+		return n.handleGetHeaders(p, request)
+	case "headers":
+		return n.handleHeaders(p, request)
+	case "ping":
+		return n.handlePing(p, request)
+	case "pong":
+		return n.handlePong(p, request)
+	case "tx":
+		return n.handleTx(request)
+	case "verack":
+		return n.handleVerack(p)
+	case "version":
+		return n.handleVersion(p, request)
+	default:
+		return misbehaving(scoreUnknownCommand, fmt.Errorf("unknown command %s", cmd))
+	}
 }
\ No newline at end of file
@@ -0,0 +1,318 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/block"
+)
+
+//同步分两步：先通过get_headers/headers用区块定位器找到分叉点并下载、验证区块头，
+//再按区块头的顺序从多个节点并行下载区块体，超时的请求交给其他节点重试
+
+//maxHeadersPerMsg 一条headers消息最多包含的区块头数量，收到满的headers消息时继续请求
+const maxHeadersPerMsg = 2000
+//maxBlocksInFlightPerPeer 每个节点同时下载的区块数
+const maxBlocksInFlightPerPeer = 16
+//downloadWindow 只下载前downloadWindow个区块头对应的区块体，乱序到达的区块在孤块池中等待
+const downloadWindow = 256
+const blockDownloadTimeout = 20 * time.Second
+const syncCheckInterval = 5 * time.Second
+//maxDownloadAttempts 区块超过该次数仍未下载成功时丢弃全部区块头，重新同步
+const maxDownloadAttempts = 5
+
+type GetHeaders struct {
+	AddrFrom	string
+	Locator		[][]byte
+	//StopHash 不为空时返回到该区块为止
+	StopHash	[]byte
+}
+
+type Headers struct {
+	AddrFrom	string
+	//Headers 使用BlockHeader.Serialize编码的区块头
+	Headers		[][]byte
+}
+
+//blockRequest 正在下载的区块体
+type blockRequest struct {
+	peer		string
+	deadline	time.Time
+	attempts	int
+}
+
+func (n *Node) requestHeaders() {
+	for _, p := range n.getPeers() {
+		n.sendGetHeaders(p)
+	}
+}
+
+func (n *Node) sendGetHeaders(p *peer) {
+	n.syncLock.Lock()
+	locator := n.headers.Locator()
+	n.syncLock.Unlock()
+
+	payload := encodePayload(&GetHeaders{n.addr, locator, nil})
+	n.sendTo(p, "get_headers", payload)
+}
+
+func (n *Node) sendHeaders(p *peer, headers []block.BlockHeader) {
+	data := Headers{AddrFrom: n.addr}
+	for _, h := range headers {
+		data.Headers = append(data.Headers, h.Serialize())
+	}
+
+	payload := encodePayload(&data)
+	n.sendTo(p, "headers", payload)
+}
+
+func (n *Node) handleGetHeaders(p *peer, request []byte) error {
+	var payload GetHeaders
+
+	err := decodePayload(request, &payload)
+	if err != nil {
+		return misbehaving(scoreMalformedMessage, err)
+	}
+
+	headers := n.bc.GetHeadersAfter(payload.Locator, payload.StopHash, maxHeadersPerMsg)
+	n.sendHeaders(p, headers)
+
+	return nil
+}
+
+func (n *Node) handleHeaders(p *peer, request []byte) error {
+	var payload Headers
+
+	err := decodePayload(request, &payload)
+	if err != nil {
+		return misbehaving(scoreMalformedMessage, err)
+	}
+
+	if len(payload.Headers) > maxHeadersPerMsg {
+		return misbehaving(scoreMalformedMessage, fmt.Errorf("%d headers exceed the limit", len(payload.Headers)))
+	}
+
+	var headers []block.BlockHeader
+	for _, data := range payload.Headers {
+		h, err := block.DecodeHeader(data)
+		if err != nil {
+			return misbehaving(scoreMalformedMessage, err)
+		}
+		headers = append(headers, h)
+	}
+	fmt.Printf("Received %d headers\n", len(headers))
+
+	if len(headers) == 0 {
+		return nil
+	}
+
+	n.syncLock.Lock()
+	added, err := n.headers.Add(headers)
+	if last := headers[len(headers) - 1]; err == nil && last.Height > n.peerHeights[p.addr] {
+		n.peerHeights[p.addr] = last.Height
+	}
+	n.syncLock.Unlock()
+
+	switch {
+	case errors.Is(err, block.ErrUnconnectedHeaders) || errors.Is(err, block.ErrTimeTooNew):
+		//对方的主链可能在请求之后发生了切换，或者两个节点的时钟不一致
+		fmt.Printf("Rejected headers from %s: %s\n", p.conn.RemoteAddr(), err)
+
+		return nil
+	case err != nil:
+		return misbehaving(scoreInvalidBlock, fmt.Errorf("invalid headers: %s", err))
+	}
+	if len(added) > 0 {
+		fmt.Printf("Added %d headers\n", len(added))
+	}
+
+	if len(headers) == maxHeadersPerMsg {
+		n.sendGetHeaders(p)
+	}
+
+	n.scheduleDownloads()
+
+	return nil
+}
+
+//scheduleDownloads 把还没有下载的区块分配给高度足够且下载数量最少的节点
+func (n *Node) scheduleDownloads() {
+	requests := make(map[string][][]byte)
+
+	n.syncLock.Lock()
+	n.headers.Prune()
+
+	load := make(map[string]int)
+	for _, r := range n.inFlight {
+		load[r.peer]++
+	}
+
+	for _, hash := range n.headers.Missing(downloadWindow) {
+		key := hex.EncodeToString(hash)
+		if _, ok := n.inFlight[key]; ok {
+			continue
+		}
+
+		h, _ := n.headers.Get(hash)
+		addr := n.selectPeer(h.Height, load, "")
+		if addr == "" {
+			break
+		}
+
+		n.inFlight[key] = &blockRequest{addr, time.Now().Add(blockDownloadTimeout), 1}
+		load[addr]++
+		requests[addr] = append(requests[addr], hash)
+	}
+	n.syncLock.Unlock()
+
+	for addr, hashes := range requests {
+		for _, hash := range hashes {
+			n.sendGetData(addr, "block", hash)
+		}
+	}
+}
+
+//selectPeer 返回高度不低于height、下载数量最少的节点，尽量不选择exclude，调用者需要持有n.syncLock
+func (n *Node) selectPeer(height int, load map[string]int, exclude string) string {
+	best := ""
+
+	for addr, peerHeight := range n.peerHeights {
+		if peerHeight < height || load[addr] >= maxBlocksInFlightPerPeer {
+			continue
+		}
+
+		switch {
+		case best == "":
+			best = addr
+		case best == exclude && addr != exclude:
+			best = addr
+		case addr != exclude && load[addr] < load[best]:
+			best = addr
+		}
+	}
+
+	return best
+}
+
+//checkDownloads 把超时的下载请求交给其他节点，多次失败时丢弃区块头重新同步
+func (n *Node) checkDownloads() {
+	requests := make(map[string][][]byte)
+	resync := false
+	now := time.Now()
+
+	n.syncLock.Lock()
+	load := make(map[string]int)
+	for _, r := range n.inFlight {
+		load[r.peer]++
+	}
+
+	for key, r := range n.inFlight {
+		if now.Before(r.deadline) {
+			continue
+		}
+
+		hash, _ := hex.DecodeString(key)
+		h, ok := n.headers.Get(hash)
+		if !ok {
+			delete(n.inFlight, key)
+
+			continue
+		}
+		if r.attempts >= maxDownloadAttempts {
+			resync = true
+
+			break
+		}
+
+		load[r.peer]--
+		addr := n.selectPeer(h.Height, load, r.peer)
+		if addr == "" {
+			delete(n.inFlight, key)
+
+			continue
+		}
+		fmt.Printf("Download of block %s from %s timed out, retrying from %s\n", key, r.peer, addr)
+
+		r.peer = addr
+		r.deadline = now.Add(blockDownloadTimeout)
+		r.attempts++
+		load[addr]++
+		requests[addr] = append(requests[addr], hash)
+	}
+
+	if resync {
+		n.headers.Reset()
+		n.inFlight = make(map[string]*blockRequest)
+	}
+	n.syncLock.Unlock()
+
+	if resync {
+		fmt.Println("Block download failed too many times, restarting sync")
+		n.requestHeaders()
+
+		return
+	}
+
+	for addr, hashes := range requests {
+		for _, hash := range hashes {
+			n.sendGetData(addr, "block", hash)
+		}
+	}
+
+	n.scheduleDownloads()
+}
+
+//blockReceived 区块到达后清除下载请求并继续下载
+func (n *Node) blockReceived(hash []byte) {
+	n.syncLock.Lock()
+	delete(n.inFlight, hex.EncodeToString(hash))
+	n.syncLock.Unlock()
+
+	n.scheduleDownloads()
+}
+
+//peerDisconnected 清除节点的高度和下载请求，请求在下一次scheduleDownloads时分配给其他节点
+func (n *Node) peerDisconnected(addr string) {
+	n.syncLock.Lock()
+	defer n.syncLock.Unlock()
+
+	delete(n.peerHeights, addr)
+
+	for key, r := range n.inFlight {
+		if r.peer == addr {
+			delete(n.inFlight, key)
+		}
+	}
+}
+
+func (n *Node) setPeerHeight(addr string, height int) {
+	n.syncLock.Lock()
+	defer n.syncLock.Unlock()
+
+	n.peerHeights[addr] = height
+}
+
+//syncLoop 定期检查下载超时，直到ctx被取消
+func (n *Node) syncLoop(ctx context.Context) {
+	ticker := time.NewTicker(syncCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n.checkDownloads()
+		}
+	}
+}
+
+func (n *Node) hasHeader(hash []byte) bool {
+	n.syncLock.Lock()
+	defer n.syncLock.Unlock()
+
+	return n.headers.Has(hash)
+}
\ No newline at end of file
@@ -1,19 +1,38 @@
 package transaction
 
 import (
-	"bytes"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
-	"encoding/gob"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"math/big"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/codec"
 )
 
-const subsidy = 10
+//txVersion 交易编码格式的版本
+const txVersion = 1
+//minInputLen和minOutputLen 编码后Input和Output的最小字节数
+const minInputLen = 7
+const minOutputLen = 9
+
+//initialSubsidy 挖出一个区块的初始奖励，每HalvingInterval个区块减半
+const initialSubsidy = 10
+
+//CoinBaseMaturity Coinbase交易所在区块之后需要再有这么多个区块，它的输出才能被花费
+const CoinBaseMaturity = 10
+
+//MaxMoney 单个输出以及任意输出总额的上限，求和前检查每一项和累计值，防止整数溢出
+const MaxMoney = 21000000
+
+//HalvingInterval 奖励减半的区块间隔
+var HalvingInterval = 100
+
+var ErrValueOutOfRange = errors.New("transaction value is out of range")
 
 type Transaction struct {
 	Id	[]byte
@@ -21,7 +40,8 @@ type Transaction struct {
 	Out []TxOutput
 }
 
-func NewCoinBaseTx(to, data string) *Transaction {
+//NewCoinBaseTx 创建高度为height的区块中的Coinbase交易，向to支付该高度的奖励和fees
+func NewCoinBaseTx(to, data string, height, fees int) *Transaction {
 	if data == "" {
 		randData := make([]byte, 20)
 		_, err := rand.Read(randData)
@@ -33,18 +53,31 @@ func NewCoinBaseTx(to, data string) *Transaction {
 	}
 
 	txIn := TxInput{[]byte{}, -1, nil, []byte(data)}
-	txOut := NewTxOutput(subsidy, to)
+	txOut := NewTxOutput(GetSubsidy(height) + fees, to)
 	tx := Transaction{nil, []TxInput{txIn}, []TxOutput{*txOut}}
 	tx.Id = tx.Hash()
 
 	return &tx
 }
 
-func DeserializeTransaction(data []byte) Transaction {
-	var tx Transaction
+//GetSubsidy 返回高度为height的区块的奖励，不包括手续费
+func GetSubsidy(height int) int {
+	halvings := height / HalvingInterval
+	if halvings >= 64 {
+		return 0
+	}
 
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&tx)
+	return initialSubsidy >> uint(halvings)
+}
+
+//IsMatureCoinBase 返回高度为height的Coinbase交易的输出能否在高度为spendHeight的区块中被花费
+//创世块不会被断开，它的输出不受限制
+func IsMatureCoinBase(height, spendHeight int) bool {
+	return height == 0 || spendHeight - height >= CoinBaseMaturity
+}
+
+func DeserializeTransaction(data []byte) Transaction {
+	tx, err := DecodeTransaction(data)
 	if err != nil {
 		log.Panic(err)
 	}
@@ -52,29 +85,155 @@ func DeserializeTransaction(data []byte) Transaction {
 	return tx
 }
 
+//DecodeTransaction 解析其他节点发送的交易，数据格式错误时返回error而不是panic
+//Id不在编码中，解析后重新计算
+func DecodeTransaction(data []byte) (Transaction, error) {
+	r := codec.NewReader(data)
+	tx, err := ReadTransaction(r)
+	if err != nil {
+		return tx, err
+	}
+
+	return tx, r.Finish()
+}
+
+//ReadTransaction 从r读取一笔交易，用于解析包含多笔交易的数据
+func ReadTransaction(r *codec.Reader) (Transaction, error) {
+	var tx Transaction
+
+	if v := r.ReadUint32(); r.Err() == nil && v != txVersion {
+		return tx, fmt.Errorf("unknown transaction version %d", v)
+	}
+
+	inNum := r.ReadCount(minInputLen)
+	for i := 0; i < inNum; i++ {
+		var in TxInput
+		in.TxId = r.ReadVarBytes()
+		in.Out = int(int32(r.ReadUint32()))
+		in.Signature = r.ReadVarBytes()
+		in.PubKey = r.ReadVarBytes()
+		tx.In = append(tx.In, in)
+	}
+
+	outNum := r.ReadCount(minOutputLen)
+	for i := 0; i < outNum; i++ {
+		var out TxOutput
+		out.Value = int(r.ReadInt64())
+		out.PubKeyHash = r.ReadVarBytes()
+		tx.Out = append(tx.Out, out)
+	}
+
+	if r.Err() != nil {
+		return Transaction{}, r.Err()
+	}
+	tx.Id = tx.Hash()
+
+	return tx, nil
+}
+
 func (tx Transaction) IsCoinBase() bool {
 	return len(tx.In) == 1 && len(tx.In[0].TxId) == 0 && tx.In[0].Out == -1
 }
 
-func (tx Transaction) Serialize() []byte {
-	var encoded bytes.Buffer
+//OutputValue 返回所有Output的总额，任一输出或累计值不在0到MaxMoney之间时返回ErrValueOutOfRange
+func (tx Transaction) OutputValue() (int, error) {
+	value := 0
+
+	for _, out := range tx.Out {
+		if !MoneyRange(out.Value) {
+			return 0, ErrValueOutOfRange
+		}
+		value += out.Value
+		if !MoneyRange(value) {
+			return 0, ErrValueOutOfRange
+		}
+	}
+
+	return value, nil
+}
+
+//Fee 返回Input引用的输出总额减去Output总额，prevTxs需要包含所有Input引用的交易
+//金额不在0到MaxMoney之间时返回ErrValueOutOfRange
+func (tx Transaction) Fee(prevTxs map[string]Transaction) (int, error) {
+	if tx.IsCoinBase() {
+		return 0, nil
+	}
+
+	value := 0
+	for _, in := range tx.In {
+		prevTx := prevTxs[hex.EncodeToString(in.TxId)]
+		if !MoneyRange(prevTx.Out[in.Out].Value) {
+			return 0, ErrValueOutOfRange
+		}
+		value += prevTx.Out[in.Out].Value
+		if !MoneyRange(value) {
+			return 0, ErrValueOutOfRange
+		}
+	}
 
-	encoder := gob.NewEncoder(&encoded)
-	err := encoder.Encode(tx)
+	outputValue, err := tx.OutputValue()
 	if err != nil {
-		log.Panic(err)
+		return 0, err
 	}
 
-	return encoded.Bytes()
+	return value - outputValue, nil
+}
+
+//MoneyRange 返回value是否在0到MaxMoney之间
+func MoneyRange(value int) bool {
+	return value >= 0 && value <= MaxMoney
+}
+
+//Serialize 返回交易的规范编码，用于存储、网络传输和Merkle树
+func (tx Transaction) Serialize() []byte {
+	w := codec.NewWriter()
+	tx.write(w, true)
+
+	return w.Bytes()
+}
+
+//MerkleLeaf 返回交易在区块Merkle树中的叶子，即包含签名的规范编码的SHA-256
+//Id不包含签名，叶子包含签名使区块承诺交易的签名，验证Merkle证明时使用叶子而不是Id
+func (tx Transaction) MerkleLeaf() []byte {
+	hash := sha256.Sum256(tx.Serialize())
+
+	return hash[:]
 }
 
+//write 按以下格式写入交易，Id不写入
+//version(4) + Input数量(varint) + Input... + Output数量(varint) + Output...
+//Input：TxId(varbytes) + Out(4，-1编码为0xffffffff) + Signature(varbytes) + PubKey(varbytes)
+//Output：Value(8) + PubKeyHash(varbytes)
+func (tx Transaction) write(w *codec.Writer, withSignatures bool) {
+	w.WriteUint32(txVersion)
+
+	w.WriteVarInt(uint64(len(tx.In)))
+	for _, in := range tx.In {
+		w.WriteVarBytes(in.TxId)
+		w.WriteUint32(uint32(int32(in.Out)))
+		if withSignatures {
+			w.WriteVarBytes(in.Signature)
+		} else {
+			w.WriteVarBytes(nil)
+		}
+		w.WriteVarBytes(in.PubKey)
+	}
+
+	w.WriteVarInt(uint64(len(tx.Out)))
+	for _, out := range tx.Out {
+		w.WriteInt64(int64(out.Value))
+		w.WriteVarBytes(out.PubKeyHash)
+	}
+}
+
+//Hash 返回不包含签名的规范编码的SHA-256，签名不改变交易Id
 func (tx *Transaction) Hash() []byte {
 	var hash [32]byte
 
-	txCopy := *tx
-	txCopy.Id = []byte{}
+	w := codec.NewWriter()
+	tx.write(w, false)
 
-	hash = sha256.Sum256(txCopy.Serialize())
+	hash = sha256.Sum256(w.Bytes())
 
 	return hash[:]
 }
@@ -97,6 +256,45 @@ func (tx *Transaction) TrimmedCopy() Transaction {
 	return txCopy
 }
 
+//Sign 用私钥对Transaction的每个Input签名，签名为定长的r||s，Verify按长度一半拆分
+//Input的PubKey需要在计算Id之前设置为私钥对应的X||Y
+//签名的数据为TrimmedCopy的规范编码的SHA-256，其中当前Input的PubKey替换为引用输出的PubKeyHash
+func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTxs map[string]Transaction) {
+	if tx.IsCoinBase() {
+		return
+	}
+
+	for _, input := range tx.In {
+		if prevTxs[hex.EncodeToString(input.TxId)].Id == nil {
+			log.Panic("Error: Previous transaction is not correct")
+		}
+	}
+
+	txCopy := tx.TrimmedCopy()
+	intLen := (privKey.Curve.Params().BitSize + 7) / 8
+
+	for id, input := range txCopy.In {
+		prevTx := prevTxs[hex.EncodeToString(input.TxId)]
+		txCopy.In[id].Signature = nil
+		txCopy.In[id].PubKey = prevTx.Out[input.Out].PubKeyHash
+
+		dataToSign := sha256.Sum256(txCopy.Serialize())
+
+		r, s, err := ecdsa.Sign(rand.Reader, &privKey, dataToSign[:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+		signature := make([]byte, 2 * intLen)
+		r.FillBytes(signature[:intLen])
+		s.FillBytes(signature[intLen:])
+		tx.In[id].Signature = signature
+
+		txCopy.In[id].PubKey = nil
+	}
+}
+
+//Verify 验证每个Input的PubKey的哈希等于引用输出的PubKeyHash，并且签名有效
 func (tx *Transaction) Verify(prevTxs map[string]Transaction) bool {
 	if tx.IsCoinBase() {
 		return true
@@ -113,6 +311,10 @@ func (tx *Transaction) Verify(prevTxs map[string]Transaction) bool {
 
 	for id, input := range tx.In {
 		prevTx := prevTxs[hex.EncodeToString(input.TxId)]
+		//签名只能证明签名者持有PubKey对应的私钥，PubKey还必须是输出锁定的公钥
+		if !input.IsKeyUsed(prevTx.Out[input.Out].PubKeyHash) {
+			return false
+		}
 		txCopy.In[id].Signature = nil
 		txCopy.In[id].PubKey = prevTx.Out[input.Out].PubKeyHash
 
@@ -128,10 +330,10 @@ func (tx *Transaction) Verify(prevTxs map[string]Transaction) bool {
 		x.SetBytes(input.PubKey[:(keyLen / 2)])
 		y.SetBytes(input.PubKey[(keyLen / 2):])
 
-		dataToVerify := fmt.Sprintf("%x\n", txCopy)
+		dataToVerify := sha256.Sum256(txCopy.Serialize())
 
 		rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
-		if ecdsa.Verify(&rawPubKey, []byte(dataToVerify), &r, &s) == false {
+		if ecdsa.Verify(&rawPubKey, dataToVerify[:], &r, &s) == false {
 			return false
 		}
 
@@ -2,12 +2,15 @@ package transaction
 
 import (
 	"bytes"
-	"encoding/gob"
 	"log"
+	"sort"
 
 	"github.com/pylrichard/building_block_chain_in_go/simple/codec"
 )
 
+//outputsVersion TxOutputs编码格式的版本
+const outputsVersion = 1
+
 type TxOutput struct {
 	Value		int
 	PubKeyHash	[]byte
@@ -30,27 +33,67 @@ func NewTxOutput(value int, addr string) *TxOutput {
 	return output
 }
 
+//TxOutputs 保存一笔交易中未花费的输出，key为输出在交易中的索引，部分输出被花费后索引保持不变
+//Height和CoinBase记录交易所在区块的高度以及是否为Coinbase交易，用于检查Coinbase成熟度
 type TxOutputs struct {
-	Outputs []TxOutput
+	Outputs		map[int]TxOutput
+	Height		int
+	CoinBase	bool
+}
+
+//IsSpendable 返回这些输出能否在高度为spendHeight的区块中被花费
+func (outs TxOutputs) IsSpendable(spendHeight int) bool {
+	return !outs.CoinBase || IsMatureCoinBase(outs.Height, spendHeight)
 }
 
+//Serialize 按以下格式编码，输出按索引从小到大排列
+//version(4) + Height(varint) + CoinBase(1) + 输出数量(varint) + (索引(varint) + Value(8) + PubKeyHash(varbytes))...
 func (outs TxOutputs) Serialize() []byte {
-	var buff bytes.Buffer
+	var indexes []int
 
-	encoder := gob.NewEncoder(&buff)
-	err := encoder.Encode(outs)
-	if err != nil {
-		log.Panic(err)
+	for outIdx := range outs.Outputs {
+		indexes = append(indexes, outIdx)
+	}
+	sort.Ints(indexes)
+
+	w := codec.NewWriter()
+	w.WriteUint32(outputsVersion)
+	w.WriteVarInt(uint64(outs.Height))
+	if outs.CoinBase {
+		w.WriteUint8(1)
+	} else {
+		w.WriteUint8(0)
 	}
 
-	return buff.Bytes()
+	w.WriteVarInt(uint64(len(indexes)))
+	for _, outIdx := range indexes {
+		out := outs.Outputs[outIdx]
+		w.WriteVarInt(uint64(outIdx))
+		w.WriteInt64(int64(out.Value))
+		w.WriteVarBytes(out.PubKeyHash)
+	}
+
+	return w.Bytes()
 }
 
 func DeserializeOutputs(data []byte) TxOutputs {
-	var outputs TxOutputs
+	outputs := TxOutputs{Outputs: make(map[int]TxOutput)}
+	r := codec.NewReader(data)
+
+	if v := r.ReadUint32(); r.Err() == nil && v != outputsVersion {
+		log.Panicf("unknown outputs version %d", v)
+	}
+	outputs.Height = int(r.ReadVarInt())
+	outputs.CoinBase = r.ReadUint8() == 1
+
+	num := r.ReadCount(minOutputLen)
+	for i := 0; i < num; i++ {
+		outIdx := int(r.ReadVarInt())
+		value := int(r.ReadInt64())
+		outputs.Outputs[outIdx] = TxOutput{value, r.ReadVarBytes()}
+	}
 
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&outputs)
+	err := r.Finish()
 	if err != nil {
 		log.Panic(err)
 	}
@@ -0,0 +1,83 @@
+package transaction
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/wallet"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	from := wallet.NewWallet()
+	to := wallet.NewWallet()
+
+	prevTx := NewCoinBaseTx(string(from.GetAddr()), "", 0, 0)
+	prevTxs := map[string]Transaction{hex.EncodeToString(prevTx.Id): *prevTx}
+
+	input := TxInput{prevTx.Id, 0, nil, from.PublicKey}
+	output := NewTxOutput(initialSubsidy, string(to.GetAddr()))
+	tx := Transaction{nil, []TxInput{input}, []TxOutput{*output}}
+	tx.Id = tx.Hash()
+
+	tx.Sign(from.PrivateKey, prevTxs)
+	assert.Equal(t, 64, len(tx.In[0].Signature))
+	assert.Equal(t, tx.Id, tx.Hash())
+
+	decoded, err := DecodeTransaction(tx.Serialize())
+	assert.Nil(t, err)
+	assert.Equal(t, tx, decoded)
+
+	_, err = DecodeTransaction(append(tx.Serialize(), 0))
+	assert.NotNil(t, err)
+	assert.True(t, tx.Verify(prevTxs))
+	fee, err := tx.Fee(prevTxs)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, fee)
+
+	tx.Out[0].Value = initialSubsidy * 2
+	tx.Id = tx.Hash()
+	fee, err = tx.Fee(prevTxs)
+	assert.Nil(t, err)
+	assert.Equal(t, -initialSubsidy, fee)
+	assert.False(t, tx.Verify(prevTxs))
+
+	//用自己的密钥签名不能花费其他地址的输出
+	thief := wallet.NewWallet()
+	stolen := Transaction{nil, []TxInput{{prevTx.Id, 0, nil, thief.PublicKey}}, []TxOutput{*NewTxOutput(initialSubsidy, string(thief.GetAddr()))}}
+	stolen.Id = stolen.Hash()
+	stolen.Sign(thief.PrivateKey, prevTxs)
+	assert.False(t, stolen.Verify(prevTxs))
+}
+
+func TestOutputValueRange(t *testing.T) {
+	addr := string(wallet.NewWallet().GetAddr())
+	out := NewTxOutput(1 << 62, addr)
+	tx := Transaction{nil, nil, []TxOutput{*out, *out, *out, *out}}
+
+	//4个2^62的总和溢出为0
+	_, err := tx.OutputValue()
+	assert.ErrorIs(t, err, ErrValueOutOfRange)
+
+	tx.Out = []TxOutput{*NewTxOutput(MaxMoney, addr), *NewTxOutput(1, addr)}
+	_, err = tx.OutputValue()
+	assert.ErrorIs(t, err, ErrValueOutOfRange)
+
+	tx.Out = []TxOutput{*NewTxOutput(-1, addr)}
+	_, err = tx.OutputValue()
+	assert.ErrorIs(t, err, ErrValueOutOfRange)
+
+	tx.Out = []TxOutput{*NewTxOutput(MaxMoney - 1, addr), *NewTxOutput(1, addr)}
+	value, err := tx.OutputValue()
+	assert.Nil(t, err)
+	assert.Equal(t, MaxMoney, value)
+}
+
+func TestGetSubsidy(t *testing.T) {
+	assert.Equal(t, initialSubsidy, GetSubsidy(0))
+	assert.Equal(t, initialSubsidy, GetSubsidy(HalvingInterval - 1))
+	assert.Equal(t, initialSubsidy / 2, GetSubsidy(HalvingInterval))
+	assert.Equal(t, initialSubsidy / 4, GetSubsidy(HalvingInterval * 2))
+	assert.Equal(t, 0, GetSubsidy(HalvingInterval * 64))
+}
\ No newline at end of file
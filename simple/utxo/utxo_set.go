@@ -1,12 +1,209 @@
 package utxo
 
-import "github.com/pylrichard/building_block_chain_in_go/simple/block"
+import (
+	"encoding/hex"
+	"log"
+	"sort"
 
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/block"
+	"github.com/pylrichard/building_block_chain_in_go/simple/transaction"
+)
+
+//Set 在block.ChainStateBucket中保存主链上所有未花费的交易输出，key为交易Id
+//chainstate由block包在切换主链的同一个bolt事务中更新
 type Set struct {
 	Chain *block.Chain
 }
 
-//Reindex 重新构建UTXO Set
-func (u Set) Reindex() {
+//FindSpendableOutputs 找到pubKeyHash锁定的、总额至少为amount的UTXO，跳过未成熟的Coinbase输出
+func (u Set) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
+	unspentOutputs := make(map[string][]int)
+	accumulated := 0
+	db := u.Chain.Db
+	spendHeight := u.Chain.GetBestHeight() + 1
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(block.ChainStateBucket))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+
+		for k, v := c.First(); k != nil && accumulated < amount; k, v = c.Next() {
+			txId := hex.EncodeToString(k)
+			outs := transaction.DeserializeOutputs(v)
+			if !outs.IsSpendable(spendHeight) {
+				continue
+			}
+
+			for outIdx, out := range outs.Outputs {
+				if out.IsLockedWithKey(pubKeyHash) && accumulated < amount {
+					accumulated += out.Value
+					unspentOutputs[txId] = append(unspentOutputs[txId], outIdx)
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return accumulated, unspentOutputs
+}
+
+//FindUTXO 找到pubKeyHash锁定的所有UTXO
+func (u Set) FindUTXO(pubKeyHash []byte) []transaction.TxOutput {
+	var UTXOs []transaction.TxOutput
+	db := u.Chain.Db
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(block.ChainStateBucket))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			outs := transaction.DeserializeOutputs(v)
+
+			for _, out := range outs.Outputs {
+				if out.IsLockedWithKey(pubKeyHash) {
+					UTXOs = append(UTXOs, out)
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return UTXOs
+}
+
+//Output UTXO Set中的一个输出，以及它所在交易的位置
+type Output struct {
+	TxId		[]byte
+	Index		int
+	Value		int
+	Height		int
+	CoinBase	bool
+	//Spendable 能否在下一个区块中被花费，未成熟的Coinbase输出不能被花费
+	Spendable	bool
+}
+
+//FindUnspent 找到pubKeyHash锁定的所有UTXO，按交易Id和输出索引排列
+func (u Set) FindUnspent(pubKeyHash []byte) []Output {
+	var UTXOs []Output
+	db := u.Chain.Db
+	spendHeight := u.Chain.GetBestHeight() + 1
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(block.ChainStateBucket))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			outs := transaction.DeserializeOutputs(v)
+			var indexes []int
 
+			for outIdx, out := range outs.Outputs {
+				if out.IsLockedWithKey(pubKeyHash) {
+					indexes = append(indexes, outIdx)
+				}
+			}
+			sort.Ints(indexes)
+
+			for _, outIdx := range indexes {
+				UTXOs = append(UTXOs, Output{
+					TxId:		append([]byte{}, k...),
+					Index:		outIdx,
+					Value:		outs.Outputs[outIdx].Value,
+					Height:		outs.Height,
+					CoinBase:	outs.CoinBase,
+					Spendable:	outs.IsSpendable(spendHeight),
+				})
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return UTXOs
+}
+
+//FindOutput 返回交易txId的第outIdx个输出，输出不存在或已被花费时返回false
+func (u Set) FindOutput(txId []byte, outIdx int) (transaction.TxOutput, bool) {
+	var out transaction.TxOutput
+	found := false
+	db := u.Chain.Db
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(block.ChainStateBucket))
+		if b == nil {
+			return nil
+		}
+
+		outsBytes := b.Get(txId)
+		if outsBytes == nil {
+			return nil
+		}
+
+		outs := transaction.DeserializeOutputs(outsBytes)
+		out, found = outs.Outputs[outIdx]
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return out, found
+}
+
+//CountTransactions 返回UTXO Set中交易的数量
+func (u Set) CountTransactions() int {
+	db := u.Chain.Db
+	counter := 0
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(block.ChainStateBucket))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			counter++
+
+			return nil
+		})
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return counter
+}
+
+//Update 用新连接到主链的区块更新UTXO Set，区块花费的输出不在UTXO Set中时返回block.ErrMissingOutput
+func (u Set) Update(b *block.Block) error {
+	return u.Chain.ConnectBlock(b)
+}
+
+//Reindex 按主链重新构建UTXO Set，以及区块断开时恢复UTXO Set需要的undo数据
+func (u Set) Reindex() {
+	err := u.Chain.ReindexChainState()
+	if err != nil {
+		log.Panic(err)
+	}
 }
\ No newline at end of file
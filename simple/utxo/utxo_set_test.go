@@ -0,0 +1,108 @@
+package utxo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/block"
+	"github.com/pylrichard/building_block_chain_in_go/simple/transaction"
+	"github.com/pylrichard/building_block_chain_in_go/simple/wallet"
+)
+
+//mineChild 在parent之后挖出只包含Coinbase交易的区块，不保存
+func mineChild(addr string, parent *block.Block) *block.Block {
+	b := &block.Block{
+		Timestamp:		parent.Timestamp + 1,
+		Transactions:	[]*transaction.Transaction{transaction.NewCoinBaseTx(addr, "", parent.Height + 1, 0)},
+		PrevBlockHash:	parent.Hash,
+		Height:			parent.Height + 1,
+		Bits:			parent.Bits,
+	}
+	b.MerkleRoot = b.HashTransaction()
+	b.Nonce, b.Hash = block.NewProofOfWork(b).Run()
+
+	return b
+}
+
+func TestSetFollowsMainChain(t *testing.T) {
+	w := wallet.NewWallet()
+	addr := string(w.GetAddr())
+	pubKeyHash := wallet.HashPubKey(w.PublicKey)
+	other := wallet.NewWallet()
+	otherAddr := string(other.GetAddr())
+	bc := block.NewChainWithGenesis(addr, t.TempDir(), "utxo_test")
+	defer bc.Db.Close()
+	set := Set{bc}
+
+	genesis, err := bc.GetBlockByHeight(0)
+	assert.Nil(t, err)
+	genesisCb := genesis.Transactions[0]
+	value := genesisCb.Out[0].Value
+
+	unspent := set.FindUnspent(pubKeyHash)
+	assert.Len(t, unspent, 1)
+	assert.Equal(t, genesisCb.Id, unspent[0].TxId)
+	assert.True(t, unspent[0].CoinBase)
+
+	//花费genesis的输出，一部分支付给other，剩余的找零
+	in := transaction.TxInput{TxId: genesisCb.Id, Out: 0, PubKey: w.PublicKey}
+	spend := &transaction.Transaction{In: []transaction.TxInput{in}, Out: []transaction.TxOutput{
+		*transaction.NewTxOutput(3, otherAddr),
+		*transaction.NewTxOutput(value - 3, addr),
+	}}
+	spend.Id = spend.Hash()
+	bc.SignTransaction(spend, w.PrivateKey)
+
+	mined, err := bc.MineBlock([]*transaction.Transaction{transaction.NewCoinBaseTx(otherAddr, "", 1, 0), spend})
+	assert.Nil(t, err)
+
+	_, ok := set.FindOutput(genesisCb.Id, 0)
+	assert.False(t, ok)
+	out, ok := set.FindOutput(spend.Id, 0)
+	assert.True(t, ok)
+	assert.Equal(t, 3, out.Value)
+	unspent = set.FindUnspent(pubKeyHash)
+	assert.Len(t, unspent, 1)
+	assert.Equal(t, spend.Id, unspent[0].TxId)
+	assert.Equal(t, 1, unspent[0].Index)
+	assert.Equal(t, 1, unspent[0].Height)
+	assert.False(t, unspent[0].CoinBase)
+	assert.Equal(t, 2, set.CountTransactions())
+
+	//重建的UTXO Set和按区块更新的相同
+	set.Reindex()
+	assert.Equal(t, unspent, set.FindUnspent(pubKeyHash))
+	assert.Equal(t, 2, set.CountTransactions())
+
+	//更长的分支断开mined，恢复被花费的genesis输出，删除mined创建的输出
+	fork := mineChild(otherAddr, &genesis)
+	_, err = bc.AddBlock(fork)
+	assert.Nil(t, err)
+	fork = mineChild(otherAddr, fork)
+	_, err = bc.AddBlock(fork)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, bc.GetBestHeight())
+
+	_, ok = set.FindOutput(spend.Id, 0)
+	assert.False(t, ok)
+	_, ok = set.FindOutput(mined.Transactions[0].Id, 0)
+	assert.False(t, ok)
+	unspent = set.FindUnspent(pubKeyHash)
+	assert.Len(t, unspent, 1)
+	assert.Equal(t, genesisCb.Id, unspent[0].TxId)
+	assert.Equal(t, value, unspent[0].Value)
+	assert.Len(t, set.FindUTXO(wallet.HashPubKey(other.PublicKey)), 2)
+
+	//Update单独应用一个区块，同一个区块不能应用两次
+	next := mineChild(otherAddr, fork)
+	assert.Nil(t, set.Update(next))
+	_, ok = set.FindOutput(next.Transactions[0].Id, 0)
+	assert.True(t, ok)
+	assert.ErrorIs(t, set.Update(next), block.ErrTxIdExists)
+
+	//重建后只包含主链上的输出
+	set.Reindex()
+	assert.Equal(t, unspent, set.FindUnspent(pubKeyHash))
+	assert.Equal(t, 3, set.CountTransactions())
+}
\ No newline at end of file
@@ -0,0 +1,63 @@
+package utxo
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/transaction"
+	"github.com/pylrichard/building_block_chain_in_go/simple/wallet"
+)
+
+//NewUTXOTransaction 从UTXO Set中选择w可以花费的输出，创建向to转账amount并支付fee手续费的交易并签名
+//选择的输出总额超过amount加fee时找零给w的地址
+func NewUTXOTransaction(w *wallet.Wallet, to string, amount, fee int, u *Set) (*transaction.Transaction, error) {
+	pubKeyHash := wallet.HashPubKey(w.PublicKey)
+	acc, validOutputs := u.FindSpendableOutputs(pubKeyHash, amount + fee)
+	tx, err := NewTransaction(w, to, amount, fee, acc, validOutputs)
+	if err != nil {
+		return nil, err
+	}
+	u.Chain.SignTransaction(tx, w.PrivateKey)
+
+	return tx, nil
+}
+
+//NewTransaction 用已经选出的、总额为acc的w的输出创建未签名的交易，validOutputs的key为交易Id的hex
+//用于不能直接访问UTXO Set的客户端，客户端取得引用的交易后调用Transaction.Sign签名
+func NewTransaction(w *wallet.Wallet, to string, amount, fee, acc int, validOutputs map[string][]int) (*transaction.Transaction, error) {
+	var inputs []transaction.TxInput
+	var outputs []transaction.TxOutput
+
+	if amount <= 0 || fee < 0 {
+		return nil, fmt.Errorf("amount %d or fee %d is not valid", amount, fee)
+	}
+
+	total := amount + fee
+	if acc < total {
+		return nil, fmt.Errorf("not enough funds, balance %d is less than %d", acc, total)
+	}
+
+	for txId, outs := range validOutputs {
+		rawTxId, err := hex.DecodeString(txId)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		for _, out := range outs {
+			input := transaction.TxInput{TxId: rawTxId, Out: out, PubKey: w.PublicKey}
+			inputs = append(inputs, input)
+		}
+	}
+
+	from := string(w.GetAddr())
+	outputs = append(outputs, *transaction.NewTxOutput(amount, to))
+	if acc > total {
+		outputs = append(outputs, *transaction.NewTxOutput(acc - total, from))
+	}
+
+	tx := transaction.Transaction{In: inputs, Out: outputs}
+	tx.Id = tx.Hash()
+
+	return &tx, nil
+}
\ No newline at end of file
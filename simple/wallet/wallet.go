@@ -2,6 +2,9 @@ package wallet
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/sha256"
 	"log"
 
@@ -10,7 +13,59 @@ import (
 	"github.com/pylrichard/building_block_chain_in_go/simple/codec"
 )
 
+const version = byte(0x00)
 const addrChecksumLen = 4
+const coordinateLen = 32
+
+//Wallet 保存一对ECDSA P-256密钥，PublicKey为X||Y，X和Y各占32字节
+type Wallet struct {
+	PrivateKey	ecdsa.PrivateKey
+	PublicKey	[]byte
+}
+
+func NewWallet() *Wallet {
+	privKey, pubKey := newKeyPair()
+	w := Wallet{privKey, pubKey}
+
+	return &w
+}
+
+//GetAddr 返回钱包地址，即Base58(version + HashPubKey(PublicKey) + checksum)
+func (w Wallet) GetAddr() []byte {
+	return PubKeyHashToAddr(HashPubKey(w.PublicKey))
+}
+
+//PubKeyHashToAddr 返回pubKeyHash对应的地址，用于显示交易输出的接收方
+func PubKeyHashToAddr(pubKeyHash []byte) []byte {
+	versionedPayload := append([]byte{version}, pubKeyHash...)
+	checksum := getChecksum(versionedPayload)
+
+	fullPayload := append(versionedPayload, checksum...)
+	addr := codec.Base58Encode(fullPayload)
+
+	return addr
+}
+
+func newKeyPair() (ecdsa.PrivateKey, []byte) {
+	curve := elliptic.P256()
+	privKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return *privKey, encodePubKey(&privKey.PublicKey)
+}
+
+//encodePubKey 把公钥编码为定长的X||Y，Transaction.Verify按长度一半拆分
+func encodePubKey(pubKey *ecdsa.PublicKey) []byte {
+	encoded := make([]byte, 2 * coordinateLen)
+	xBytes := pubKey.X.Bytes()
+	yBytes := pubKey.Y.Bytes()
+	copy(encoded[coordinateLen - len(xBytes):coordinateLen], xBytes)
+	copy(encoded[2 * coordinateLen - len(yBytes):], yBytes)
+
+	return encoded
+}
 
 func HashPubKey(pubKey []byte) []byte {
 	pubSha256 := sha256.Sum256(pubKey)
@@ -25,8 +80,16 @@ func HashPubKey(pubKey []byte) []byte {
 	return pubRipemd160
 }
 
+//ValidateAddr 检查地址的校验和，用于检查用户和RPC客户端输入的地址
 func ValidateAddr(addr string) bool {
+	if addr == "" {
+		return false
+	}
+
 	pubKeyHash := codec.Base58Decode([]byte(addr))
+	if len(pubKeyHash) <= addrChecksumLen {
+		return false
+	}
 	actualChecksum := pubKeyHash[len(pubKeyHash) - addrChecksumLen:]
 	version := pubKeyHash[0]
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash) - addrChecksumLen]
@@ -0,0 +1,25 @@
+package wallet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pylrichard/building_block_chain_in_go/simple/codec"
+)
+
+func TestWalletAddr(t *testing.T) {
+	w := NewWallet()
+	assert.Equal(t, 64, len(w.PublicKey))
+
+	addr := w.GetAddr()
+	assert.True(t, ValidateAddr(string(addr)))
+
+	decoded := codec.Base58Decode(addr)
+	assert.Equal(t, version, decoded[0])
+	assert.Equal(t, HashPubKey(w.PublicKey), decoded[1 : len(decoded) - addrChecksumLen])
+	assert.Equal(t, addr, PubKeyHashToAddr(HashPubKey(w.PublicKey)))
+
+	assert.False(t, ValidateAddr(""))
+	assert.False(t, ValidateAddr("2"))
+}
\ No newline at end of file
@@ -0,0 +1,133 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const walletFileTemplate = "wallet_%s.dat"
+
+//Wallets 保存一个节点的所有钱包，key为地址
+type Wallets struct {
+	Wallets map[string]*Wallet
+}
+
+//walletsFile 是钱包文件的内容，私钥以SEC 1 DER格式保存
+type walletsFile struct {
+	Keys map[string][]byte
+}
+
+//NewWallets 从dataDir中nodeId对应的钱包文件加载钱包，文件不存在时返回空的Wallets
+func NewWallets(dataDir, nodeId string) (*Wallets, error) {
+	ws := Wallets{}
+	ws.Wallets = make(map[string]*Wallet)
+
+	err := ws.LoadFromFile(dataDir, nodeId)
+
+	return &ws, err
+}
+
+//CreateWallet 生成新的密钥对并返回它的地址
+func (ws *Wallets) CreateWallet() string {
+	w := NewWallet()
+	addr := string(w.GetAddr())
+
+	ws.Wallets[addr] = w
+
+	return addr
+}
+
+//GetAddrs 返回钱包文件中的所有地址
+func (ws *Wallets) GetAddrs() []string {
+	var addrs []string
+
+	for addr := range ws.Wallets {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	return addrs
+}
+
+//GetWallet 根据地址返回钱包
+func (ws Wallets) GetWallet(addr string) (Wallet, error) {
+	w, ok := ws.Wallets[addr]
+	if !ok {
+		return Wallet{}, fmt.Errorf("address %s is not found in wallet file", addr)
+	}
+
+	return *w, nil
+}
+
+//LoadFromFile 从钱包文件加载钱包
+func (ws *Wallets) LoadFromFile(dataDir, nodeId string) error {
+	walletFile := walletFilePath(dataDir, nodeId)
+	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
+		return err
+	}
+
+	fileContent, err := ioutil.ReadFile(walletFile)
+	if err != nil {
+		return err
+	}
+
+	var content walletsFile
+	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
+	err = decoder.Decode(&content)
+	if err != nil {
+		return err
+	}
+
+	for addr, der := range content.Keys {
+		privKey, err := x509.ParseECPrivateKey(der)
+		if err != nil {
+			return err
+		}
+
+		ws.Wallets[addr] = &Wallet{*privKey, encodePubKey(&privKey.PublicKey)}
+	}
+
+	return nil
+}
+
+//SaveToFile 把钱包保存到钱包文件，dataDir不存在时创建
+func (ws Wallets) SaveToFile(dataDir, nodeId string) {
+	var content bytes.Buffer
+	walletFile := walletFilePath(dataDir, nodeId)
+
+	keys := walletsFile{make(map[string][]byte)}
+	for addr, w := range ws.Wallets {
+		der, err := x509.MarshalECPrivateKey(&w.PrivateKey)
+		if err != nil {
+			log.Panic(err)
+		}
+		keys.Keys[addr] = der
+	}
+
+	encoder := gob.NewEncoder(&content)
+	err := encoder.Encode(keys)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = os.MkdirAll(dataDir, 0700)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = ioutil.WriteFile(walletFile, content.Bytes(), 0600)
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+func walletFilePath(dataDir, nodeId string) string {
+	return filepath.Join(dataDir, fmt.Sprintf(walletFileTemplate, nodeId))
+}
\ No newline at end of file